@@ -0,0 +1,222 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipWriters recycles gzip.Writers between responses, each one holds a
+// sizeable internal buffer which isn't worth allocating per request
+var gzipWriters = sync.Pool{
+	New: func() any {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+// incompressibleTypes are content types which are already compressed,
+// gzipping them again only burns cpu
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/octet-stream",
+}
+
+// compress gzips response bodies for clients sending Accept-Encoding: gzip.
+// Responses smaller than -compression-min-size are sent as is, as are already
+// compressed content types
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.compression.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// caches must keep the compressed and plain responses apart
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{
+			wrapped: w,
+			minSize: app.config.compression.minSize,
+			status:  http.StatusOK,
+		}
+		// close flushes whatever is still buffered and ends the gzip stream
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, either by
+// name or through "*", and not with q=0
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+
+	return false
+}
+
+// compressResponseWriter holds back the status and the first minSize bytes of the
+// body, so it can decide whether the response is worth compressing before anything
+// reaches the client
+type compressResponseWriter struct {
+	wrapped http.ResponseWriter
+	minSize int
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+// Header returns header map from origin http.ResponseWriter that we wrapped
+func (cw *compressResponseWriter) Header() http.Header {
+	return cw.wrapped.Header()
+}
+
+// WriteHeader records the status, it is only sent once we know whether to compress
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if cw.decided {
+		return
+	}
+
+	cw.status = status
+
+	// these never have a body so there is nothing to wait for
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+// Write buffers b until minSize bytes have been written, after that it writes
+// straight through, compressing if decided so
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.minSize {
+			return len(b), nil
+		}
+
+		err := cw.decide(true)
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if cw.gz != nil {
+		return cw.gz.Write(b)
+	}
+	return cw.wrapped.Write(b)
+}
+
+// Flush sends everything written so far to the client, handlers which stream
+// their output can't wait for minSize bytes so this compresses right away
+func (cw *compressResponseWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+
+	if cw.gz != nil {
+		cw.gz.Flush()
+	}
+
+	http.NewResponseController(cw.wrapped).Flush()
+}
+
+// Unwrap returns the wrapped http.ResponseWriter
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.wrapped
+}
+
+// decide sends the headers and the buffered body, through a gzip.Writer if
+// compress is set and the response can be compressed
+func (cw *compressResponseWriter) decide(compress bool) error {
+	cw.decided = true
+
+	h := cw.wrapped.Header()
+	if compress && compressible(h) {
+		h.Set("Content-Encoding", "gzip")
+		// the length set by the handler is for the plain body
+		h.Del("Content-Length")
+
+		cw.gz = gzipWriters.Get().(*gzip.Writer)
+		cw.gz.Reset(cw.wrapped)
+	}
+
+	cw.wrapped.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.gz != nil {
+		_, err = cw.gz.Write(buf)
+	} else {
+		_, err = cw.wrapped.Write(buf)
+	}
+	return err
+}
+
+// close sends a response which never reached minSize as is, or finishes the
+// gzip stream. Without it the end of compressed bodies would be cut off
+func (cw *compressResponseWriter) close() {
+	if !cw.decided {
+		// nothing written at all means the handler left the response to the
+		// default 200 with no body, leave that to net/http too
+		if cw.status == http.StatusOK && len(cw.buf) == 0 {
+			return
+		}
+		cw.decide(false)
+	}
+
+	if cw.gz != nil {
+		cw.gz.Close()
+		cw.gz.Reset(io.Discard)
+		gzipWriters.Put(cw.gz)
+		cw.gz = nil
+	}
+}
+
+// compressible checks the response isn't already encoded or of a compressed type
+func compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	contentType := h.Get("Content-Type")
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
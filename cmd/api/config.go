@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is prepended to a flag name to get its environment variable,
+// eg -db-dsn can also be set with GREENLIGHT_DB_DSN
+const envPrefix = "GREENLIGHT_"
+
+// loadConfig fills in every flag that wasn't given on the command line, first
+// from the JSON config file at path (if any) and then from the environment.
+// That gives the precedence flags > env > file > defaults.
+//
+// The file is a JSON object keyed by flag name, eg
+//
+//	{"port": 4000, "db-dsn": "postgres://...", "cors-trusted-origins": ["http://localhost:9000"]}
+func loadConfig(fs *flag.FlagSet, path string) error {
+	// these only make sense on the command line
+	set := map[string]bool{"config": true, "version": true}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if path != "" {
+		err := loadConfigFile(fs, path, set)
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+
+		name := envName(f.Name)
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+
+		if setErr := fs.Set(f.Name, val); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", val, name, setErr)
+		}
+	})
+
+	return err
+}
+
+// loadConfigFile sets every flag found in the config file that isn't already in set
+func loadConfigFile(fs *flag.FlagSet, path string, set map[string]bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	defer file.Close()
+
+	var values map[string]any
+
+	dec := json.NewDecoder(file)
+	// keep numbers as written so ints don't come back as "4e+03"
+	dec.UseNumber()
+
+	err = dec.Decode(&values)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	for name, value := range values {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("config file %s: unknown setting %q", path, name)
+		}
+
+		if set[name] {
+			continue
+		}
+
+		var val string
+
+		switch value := value.(type) {
+		case []any:
+			// lists are space seperated on the command line, eg -cors-trusted-origins
+			parts := make([]string, len(value))
+			for i, part := range value {
+				parts[i] = fmt.Sprint(part)
+			}
+			val = strings.Join(parts, " ")
+		default:
+			val = fmt.Sprint(value)
+		}
+
+		err = fs.Set(name, val)
+		if err != nil {
+			return fmt.Errorf("config file %s: invalid value %q for %q: %w", path, val, name, err)
+		}
+	}
+
+	return nil
+}
+
+// envName turns a flag name like db-dsn into GREENLIGHT_DB_DSN
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
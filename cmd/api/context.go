@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 
 	"github.com/souvikmndl/greenlight-api/internal/data"
@@ -10,8 +11,12 @@ import (
 // contextKey type to prevent collisions while storing key value pairs in context
 type contextKey string
 
-// "user" key of type contextKey to store user data in context
-const userContextKey = contextKey("user")
+const (
+	// "user" key of type contextKey to store user data in context
+	userContextKey = contextKey("user")
+	// "logger" key to store the request scoped logger, tagged with the request id
+	loggerContextKey = contextKey("logger")
+)
 
 // we change the context value or "r" to include our user data as well
 // context.WithValue(r.Context(), userContextKey, user) creates as new ctx with our user
@@ -31,3 +36,20 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// contextSetLogger returns a copy of r carrying the request scoped logger
+func (app *application) contextSetLogger(r *http.Request, logger *slog.Logger) *http.Request {
+	ctx := context.WithValue(r.Context(), loggerContextKey, logger)
+	return r.WithContext(ctx)
+}
+
+// contextGetLogger fetches the request scoped logger from a request ctx. Unlike
+// contextGetUser it falls back to the app logger, as logging must never panic
+func (app *application) contextGetLogger(r *http.Request) *slog.Logger {
+	logger, ok := r.Context().Value(loggerContextKey).(*slog.Logger)
+	if !ok {
+		return app.logger
+	}
+
+	return logger
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/souvikmndl/greenlight-api/internal/i18n"
+	"github.com/souvikmndl/greenlight-api/internal/validator"
+	"golang.org/x/text/language"
 )
 
 // InternalServerErrMsg msg for 500 status code
@@ -14,19 +19,68 @@ func (app *application) logError(r *http.Request, err error) {
 		uri    = r.URL.RequestURI()
 	)
 
-	app.logger.Error(err.Error(), "method", method, "uri", uri)
+	app.contextGetLogger(r).Error(err.Error(), "method", method, "uri", uri)
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
-	env := envelope{"error": message}
+	lang := app.negotiateLanguage(w, r)
+	env := envelope{"error": localize(lang, message)}
 
-	err := app.writeJSON(w, status, env, nil)
+	// errors are also sent as XML to the clients asking for it
+	err := app.respond(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
 	}
 }
 
+// negotiateLanguage picks the language of the error messages from Accept-Language
+// and sets the Content-Language and Vary headers to match
+func (app *application) negotiateLanguage(w http.ResponseWriter, r *http.Request) language.Tag {
+	lang := i18n.Default.Negotiate(r.Header.Get("Accept-Language"))
+
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", lang.String())
+
+	return lang
+}
+
+// localize translates the messages in an error payload to lang, the payload keeps
+// its shape and the codes in validation details are left as they are
+func localize(lang language.Tag, message any) any {
+	if lang == i18n.English {
+		return message
+	}
+
+	switch message := message.(type) {
+	case string:
+		return i18n.Default.Translate(lang, message)
+	case map[string]string:
+		out := make(map[string]string, len(message))
+		for key, msg := range message {
+			out[key] = i18n.Default.Translate(lang, msg)
+		}
+		return out
+	case map[string][]string:
+		out := make(map[string][]string, len(message))
+		for key, msgs := range message {
+			for _, msg := range msgs {
+				out[key] = append(out[key], i18n.Default.Translate(lang, msg))
+			}
+		}
+		return out
+	case []validator.ValidationError:
+		out := make([]validator.ValidationError, len(message))
+		for i, detail := range message {
+			detail.Message = i18n.Default.Translate(lang, detail.Message)
+			out[i] = detail
+		}
+		return out
+	default:
+		return message
+	}
+}
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
 
@@ -45,11 +99,53 @@ func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.
 }
 
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
+	// a JSON value of the wrong type is reported against its field, the same shape
+	// as a failed validation, so clients can map it back onto their form inputs
+	var fieldErr *jsonFieldError
+	if errors.As(err, &fieldErr) {
+		app.failedValidationResponse(w, r, map[string]string{fieldErr.Field: fieldErr.Message},
+			validator.ValidationError{Field: fieldErr.Field, Code: validator.CodeInvalid, Message: fieldErr.Message})
+		return
+	}
+
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+// failedValidationResponse sends the errors as a key to message map under "error", as
+// it always has. Any details go next to it under "details" so clients can switch on the
+// error codes, eg {"field": "title", "code": "too_long", "message": "..."}
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string, details ...validator.ValidationError) {
+	app.validationErrorResponse(w, r, errors, details)
+}
+
+// failedMultiValidationResponse is failedValidationResponse for a validator.MultiValidator,
+// each key maps to a list of messages. registerUserHandler and updateUserPasswordHandler
+// use it so every password rule that failed is reported at once
+func (app *application) failedMultiValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string][]string, details ...validator.ValidationError) {
+	app.validationErrorResponse(w, r, errors, details)
+}
+
+func (app *application) validationErrorResponse(w http.ResponseWriter, r *http.Request, errors any, details []validator.ValidationError) {
+	lang := app.negotiateLanguage(w, r)
+
+	env := envelope{"error": localize(lang, errors)}
+	if len(details) > 0 {
+		env["details"] = localize(lang, details)
+	}
+
+	err := app.respond(w, r, http.StatusUnprocessableEntity, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+// duplicateMovieResponse reports a movie clashing with the unique title and year
+// index of -enforce-unique-movies, against the title like a failed validation
+func (app *application) duplicateMovieResponse(w http.ResponseWriter, r *http.Request, key string) {
+	message := "a movie with this title and year already exists"
+	app.failedValidationResponse(w, r, map[string]string{key: message},
+		validator.ValidationError{Field: key, Code: validator.CodeAlreadyExists, Message: message})
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
@@ -57,6 +153,23 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource does not match the expected version"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+func (app *application) maintenanceResponse(w http.ResponseWriter, r *http.Request, mode maintenanceMode) {
+	message := "the server is down for maintenance, please try again later"
+	if mode == maintenanceReadOnly {
+		message = "the server is read-only for maintenance, please try again later"
+	}
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
@@ -84,9 +197,7 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
-/*
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your user account doesnt have the necessary permissions to access this resource"
+	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
-*/
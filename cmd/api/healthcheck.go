@@ -1,16 +1,77 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/souvikmndl/greenlight-api/internal/vcs"
 )
 
+// healthCheckHandler is the readiness check. It only reports the app as available
+// when the database can be reached, and responds 503 otherwise
 func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
 	data := envelope{
-		"status": "available",
-		"system_info": map[string]string{
-			"environment": app.config.env,
-			"version":     version,
-		},
+		"status":      "available",
+		"system_info": app.systemInfo(),
+		"maintenance": app.maintenanceState().String(),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	err := app.db.PingContext(ctx)
+	if err != nil {
+		app.logError(r, err)
+
+		status = http.StatusServiceUnavailable
+		data["status"] = "unavailable"
+	}
+
+	// pool utilization helps diagnose connection exhaustion, but it says a bit
+	// too much about the deployment to hand out unauthenticated in production
+	if app.config.env != "production" {
+		data["db"] = app.dbPoolStats()
+	}
+
+	err = app.writeJSON(w, status, data, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// dbPoolStats is the part of sql.DBStats the readiness check reports
+type dbPoolStats struct {
+	MaxOpenConnections int   `json:"max_open_connections"`
+	OpenConnections    int   `json:"open_connections"`
+	InUse              int   `json:"in_use"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"wait_count"`
+	WaitDurationMS     int64 `json:"wait_duration_ms"`
+}
+
+func (app *application) dbPoolStats() dbPoolStats {
+	stats := app.db.Stats()
+
+	return dbPoolStats{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDurationMS:     stats.WaitDuration.Round(time.Millisecond).Milliseconds(),
+	}
+}
+
+// liveHealthCheckHandler is the liveness check, it responds 200 as long as the
+// process is running without touching any dependencies like the database
+func (app *application) liveHealthCheckHandler(w http.ResponseWriter, r *http.Request) {
+	data := envelope{
+		"status":      "available",
+		"system_info": app.systemInfo(),
+		"maintenance": app.maintenanceState().String(),
 	}
 
 	err := app.writeJSON(w, http.StatusOK, data, nil)
@@ -18,3 +79,12 @@ func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) systemInfo() map[string]string {
+	return map[string]string{
+		"environment": app.config.env,
+		"version":     version,
+		"vcs_time":    vcs.RevisionTime(),
+		"vcs_dirty":   strconv.FormatBool(vcs.Modified()),
+	}
+}
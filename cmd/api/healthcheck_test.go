@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestLiveHealthCheck(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	res := ts.request(t, http.MethodGet, "/v1/healthcheck/live", "", nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", res.status, http.StatusOK)
+	}
+
+	env := res.envelope(t)
+	if got := field(env, "status"); got != "available" {
+		t.Errorf("got status %v, want available", got)
+	}
+	if got := field(env, "system_info", "environment"); got != "development" {
+		t.Errorf("got environment %v, want development", got)
+	}
+	if got := field(env, "maintenance"); got != "off" {
+		t.Errorf("got maintenance %v, want off", got)
+	}
+}
+
+func TestReadyHealthCheck(t *testing.T) {
+	app := newTestApplication(t)
+	useTestDB(t, app)
+	ts := newTestServer(t, app.routes())
+
+	for _, path := range []string{"/v1/healthcheck", "/v1/healthcheck/ready"} {
+		t.Run(path, func(t *testing.T) {
+			res := ts.request(t, http.MethodGet, path, "", nil)
+			if res.status != http.StatusOK {
+				t.Fatalf("got status %d, want %d", res.status, http.StatusOK)
+			}
+
+			env := res.envelope(t)
+			if got := field(env, "status"); got != "available" {
+				t.Errorf("got status %v, want available", got)
+			}
+			// the pool stats are only left out in production
+			if field(env, "db", "max_open_connections") == nil {
+				t.Errorf("db pool stats missing from %s", res.body)
+			}
+		})
+	}
+}
@@ -1,21 +1,42 @@
 package main
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/souvikmndl/greenlight-api/internal/data"
 	"github.com/souvikmndl/greenlight-api/internal/validator"
 )
 
 type envelope map[string]any
 
+// defaultMaxBodyBytes is the largest request body readJSON accepts, 1MB
+const defaultMaxBodyBytes = 1_048_576
+
+// formatBytes writes n the way a client would read a size limit, in MB or KB when
+// it divides evenly and in bytes otherwise
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", n>>10)
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
+
 func (app *application) readIDParams(r *http.Request) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
@@ -46,16 +67,86 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+// paginationLinks builds an RFC 5988 Link header value for a paginated list, with
+// absolute urls for the first, prev, next and last pages. Every query param but
+// page is kept as it came in. Empty metadata (no records) gives no links
+func paginationLinks(r *http.Request, metadata data.Metadata) string {
+	if metadata.CurrentPage == 0 {
+		return ""
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	link := func(page int, rel string) string {
+		qs := r.URL.Query()
+		qs.Set("page", strconv.Itoa(page))
+
+		u := url.URL{Scheme: scheme, Host: r.Host, Path: r.URL.Path, RawQuery: qs.Encode()}
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel)
+	}
+
+	links := []string{link(metadata.FirstPage, "first")}
+	if metadata.CurrentPage > metadata.FirstPage {
+		links = append(links, link(min(metadata.CurrentPage-1, metadata.LastPage), "prev"))
+	}
+	if metadata.CurrentPage < metadata.LastPage {
+		links = append(links, link(metadata.CurrentPage+1, "next"))
+	}
+	links = append(links, link(metadata.LastPage, "last"))
+
+	return strings.Join(links, ", ")
+}
+
+// notModifiedResponse answers a conditional GET whose If-None-Match matched, a 304
+// carries no body but keeps the ETag and other validator headers
+func (app *application) notModifiedResponse(w http.ResponseWriter, headers http.Header) {
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// etagMatches reports whether the If-None-Match header lists etag or is "*",
+// weak validators (W/"...") are compared by their opaque part
+func etagMatches(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
 // readJSON will try to decode the incoming JSON payload into dst and return errors if any
 /*
 JSON Decode() error using NewDecoder() from json/encoding
 
-- json.SyntexError, io.ErrEnexptedEOF --> syntax error with the JSON payload
+- json.SyntaxError, io.ErrUnexpectedEOF --> syntax error with the JSON payload
 - json.UnmarshalTypeError --> a JSON value is not appropriate for the destination Go type
 - json.InvalidUnmarshalError --> err in app code, possibly because the destination is not a pointer
-- io.EOF --> JSON being decoded in empty
+- io.EOF --> JSON being decoded is empty
 */
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	return app.readJSONWithLimit(w, r, dst, defaultMaxBodyBytes)
+}
+
+// readJSONWithLimit works like readJSON but lets the caller choose the maximum body size
+func (app *application) readJSONWithLimit(w http.ResponseWriter, r *http.Request, dst any, maxBytes int64) error {
+	// once maxBytes have been read any further reads return a *http.MaxBytesError,
+	// so a client can't exhaust our memory with a huge request body
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields() // does not allow fields not defined in the dst struct
 
@@ -64,21 +155,27 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 		var syntaxError *json.SyntaxError
 		var unmarshalTypeError *json.UnmarshalTypeError
 		var invalidUnmarshalError *json.InvalidUnmarshalError
+		var maxBytesError *http.MaxBytesError
 
 		switch {
 		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains bady-formed JSON (at character %d)", syntaxError.Offset)
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
 		case errors.Is(err, io.ErrUnexpectedEOF):
 			return errors.New("body contains badly-formed JSON")
-		// this kind of error occours when JSON value is the wrong type for the target dest
+		// this kind of error occurs when JSON value is the wrong type for the target dest
 		// if the err is related to a specific field, we show that else a generic msg
 		case errors.As(err, &unmarshalTypeError):
 			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+				return &jsonFieldError{
+					Field:   unmarshalTypeError.Field,
+					Message: jsonTypeMessage(unmarshalTypeError.Type),
+				}
 			}
 			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
 		case errors.Is(err, io.EOF):
 			return errors.New("body must not be empty")
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not be larger than %s", formatBytes(maxBytesError.Limit))
 		case errors.As(err, &invalidUnmarshalError):
 			panic(err) // read page 91 of Lets Go Further to understand why we are panicking here
 			// basically this means there is a logical error in our code, and should be caught in dev
@@ -87,7 +184,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 		}
 	}
 
-	//we can send multiple JSON object ina request, and attackers can use this feature
+	// we can send multiple JSON objects in a request, and attackers can use this feature
 	//to send something malicious or send huge request body to slowdown our apis(in a DDOS attack)
 	// when we call Decode() it only parses one JSON body at a time, so we need to call Decode() again, using
 	// a pointer to an empty struct. If the req body contained a single JSON, this will throw an io.EOF error.
@@ -101,6 +198,83 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	return nil
 }
 
+// errNotMultipart is returned by readMultipart when the request isn't multipart/form-data
+var errNotMultipart = errors.New("body must be multipart/form-data")
+
+// readMultipart reads the file uploaded under field in a multipart/form-data body of
+// at most maxBytes. The caller must close the returned file
+func (app *application) readMultipart(w http.ResponseWriter, r *http.Request, field string, maxBytes int64) (multipart.File, *multipart.FileHeader, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	// anything beyond the in-memory limit is spooled to temp files, which
+	// the server removes once the handler returns
+	err := r.ParseMultipartForm(maxBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.Is(err, http.ErrNotMultipart), errors.Is(err, http.ErrMissingBoundary):
+			return nil, nil, errNotMultipart
+		case errors.As(err, &maxBytesError):
+			return nil, nil, fmt.Errorf("body must not be larger than %s", formatBytes(maxBytesError.Limit))
+		default:
+			return nil, nil, fmt.Errorf("body contains a badly-formed multipart form: %w", err)
+		}
+	}
+
+	file, header, err := r.FormFile(field)
+	if err != nil {
+		if errors.Is(err, http.ErrMissingFile) {
+			return nil, nil, fmt.Errorf("body must contain a %q file", field)
+		}
+		return nil, nil, err
+	}
+
+	return file, header, nil
+}
+
+// jsonFieldError is returned by readJSON when a field in the body has the wrong JSON type.
+// badRequestResponse reports it against the field, like a failed validation
+type jsonFieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *jsonFieldError) Error() string {
+	return fmt.Sprintf("body contains incorrect JSON type for field %q", e.Field)
+}
+
+// jsonTypeMessage describes the JSON type expected for values of the Go type t
+func jsonTypeMessage(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonTypeMessage(t.Elem())
+	case reflect.String:
+		return "must be a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "must be an integer"
+	case reflect.Float32, reflect.Float64:
+		return "must be a number"
+	case reflect.Bool:
+		return "must be a boolean"
+	case reflect.Slice, reflect.Array:
+		return "must be an array"
+	default:
+		return "must be an object"
+	}
+}
+
+// readBearerToken returns the token from an "Authorization: Bearer <token>" header
+func (app *application) readBearerToken(r *http.Request) (string, error) {
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return "", errors.New("invalid or missing bearer token")
+	}
+
+	return headerParts[1], nil
+}
+
 // readString returns a string value from the query string, or the default value if no matching key
 // could be found
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
@@ -138,6 +312,56 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readTime reads an RFC3339 timestamp from the query string, the zero time is
+// returned when the key is missing or invalid
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC3339 timestamp, eg 2024-01-02T15:04:05Z")
+		return time.Time{}
+	}
+
+	return t
+}
+
+// readRuntimeFormat reads the runtime_format query param used by the movie endpoints.
+// It defaults to data.RuntimeFormatMinutes so existing clients keep getting "107 mins"
+func (app *application) readRuntimeFormat(qs url.Values, v *validator.Validator) string {
+	format := app.readString(qs, "runtime_format", data.RuntimeFormatMinutes)
+
+	v.CheckCode(validator.PermittedValue(format, data.RuntimeFormatMinutes, data.RuntimeFormatNumeric),
+		"runtime_format", validator.CodeNotPermitted, "must be either minutes or numeric")
+
+	return format
+}
+
+// validateEmail checks an email with data.ValidateEmailRFC when -email-rfc is set,
+// and with the EmailRX based data.ValidateEmail otherwise
+func (app *application) validateEmail(v validator.Checker, email string) {
+	if app.config.users.emailRFC {
+		data.ValidateEmailRFC(v, email)
+		return
+	}
+
+	data.ValidateEmail(v, email)
+}
+
+// newRequestID generates a random (version 4) UUID to identify a request
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
 // background is a wrapper func that accepts a func as a param
 // and adds recover() logic to it, and runs it as a background routine
 func (app *application) background(fn func()) {
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{100, "100 bytes"},
+		{1023, "1023 bytes"},
+		{1024, "1KB"},
+		{1536, "1536 bytes"},
+		{512 << 10, "512KB"},
+		{defaultMaxBodyBytes, "1MB"},
+		{8 * defaultMaxBodyBytes, "8MB"},
+		{1<<20 + 1<<10, "1025KB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got := formatBytes(tt.n)
+			if got != tt.want {
+				t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+// jsonBody returns a JSON object of at least size bytes
+func jsonBody(size int) string {
+	return `{"title": "` + strings.Repeat("a", size) + `"}`
+}
+
+func TestReadJSONTooLarge(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name    string
+		body    string
+		limit   int64
+		wantErr string
+	}{
+		{"default limit", jsonBody(defaultMaxBodyBytes), defaultMaxBodyBytes, "body must not be larger than 1MB"},
+		{"custom limit", jsonBody(100), 100, "body must not be larger than 100 bytes"},
+		{"just under the limit", jsonBody(10), 100, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(tt.body))
+
+			var input struct {
+				Title string `json:"title"`
+			}
+
+			err := app.readJSONWithLimit(w, r, &input, tt.limit)
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadMultipartTooLarge(t *testing.T) {
+	app := newTestApplication(t)
+
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+
+	part, err := mw.CreateFormFile("poster", "poster.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(bytes.Repeat([]byte{0}, 4096))
+	mw.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, "/v1/movies/1/poster", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	_, _, err = app.readMultipart(w, r, "poster", 1024)
+
+	want := "body must not be larger than 1KB"
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestReadJSONErrors(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"truncated", `{"title":`, "body contains badly-formed JSON"},
+		{"syntax error", `{"title": "Moana",}`, "body contains badly-formed JSON (at character 19)"},
+		{"empty", ``, "body must not be empty"},
+		{"two values", `{"title": "Moana"}{"title": "Top Gun"}`, "body must contain a single JSON value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(tt.body))
+
+			var input struct {
+				Title string `json:"title"`
+			}
+
+			err := app.readJSON(w, r, &input)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        bool
+	}{
+		{"no header", "", false},
+		{"same", `"v1"`, true},
+		{"different", `"v2"`, false},
+		{"unquoted", `v1`, false},
+		{"weak", `W/"v1"`, true},
+		{"in a list", `"v0", "v1"`, true},
+		{"not in a list", `"v0", "v2"`, false},
+		{"wildcard", `*`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+
+			if got := etagMatches(r, `"v1"`); got != tt.want {
+				t.Errorf("etagMatches(%q) = %t, want %t", tt.ifNoneMatch, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,14 +3,20 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -23,18 +29,47 @@ var version = vcs.Version()
 
 type (
 	config struct {
-		port int
-		env  string
-		db   struct {
-			dsn          string
-			maxOpenConns int
-			maxIdleConns int
-			maxIdleTime  time.Duration
+		port            int
+		env             string
+		shutdownTimeout time.Duration
+		handlerTimeout  time.Duration
+		tokenCleanup    time.Duration
+		accessLog       bool
+		http            struct {
+			idleTimeout       time.Duration
+			readTimeout       time.Duration
+			readHeaderTimeout time.Duration
+			writeTimeout      time.Duration
+		}
+		db struct {
+			dsn           string
+			host          string
+			port          int
+			user          string
+			password      string
+			name          string
+			sslmode       string
+			maxOpenConns  int
+			autoOpenConns bool
+			maxIdleConns  int
+			maxIdleTime   time.Duration
+			queryTimeout  time.Duration
+			retries       int
+			backoff       time.Duration
+		}
+		proxy struct {
+			trust          bool
+			trustedProxies []netip.Prefix
 		}
 		limiter struct {
-			rps     float64
-			burst   int
-			enabled bool
+			rps         float64
+			burst       int
+			enabled     bool
+			mode        string
+			globalRPS   float64
+			globalBurst int
+			authRPS     float64
+			authBurst   int
 		}
 		smtp struct {
 			host     string
@@ -42,62 +77,104 @@ type (
 			username string
 			password string
 			sender   string
+			timeout  time.Duration
+			retries  int
+			backoff  time.Duration
+			workers  int
+			queue    int
+			disabled bool
 		}
 		cors struct {
 			trustedOrigins []string
 		}
+		users struct {
+			defaultPermissions []string
+			emailRFC           bool
+		}
+		metrics struct {
+			enabled bool
+		}
+		otel struct {
+			endpoint string
+		}
+		compression struct {
+			enabled bool
+			minSize int
+		}
+		movies struct {
+			minYear int
+			unique  bool
+		}
+		maintenance struct {
+			mode       string
+			retryAfter time.Duration
+		}
+		posters struct {
+			dir     string
+			maxSize int64
+		}
+		log struct {
+			level  string
+			format string
+		}
 	}
 
 	application struct {
-		config config
-		logger *slog.Logger
-		models data.Models
-		mailer *mailer.Mailer
-		wg     sync.WaitGroup
+		config    config
+		logger    *slog.Logger
+		db        *sql.DB
+		models    data.Models
+		mailQueue *mailer.Queue
+		prom      *promMetrics
+		wg        sync.WaitGroup
+
+		// the settings SIGHUP can change, see reload.go
+		live atomic.Pointer[liveConfig]
+		// a maintenanceMode, toggled with SIGUSR1
+		inMaintenance atomic.Int32
+		logLevel      *slog.LevelVar
+		configFile    string
 	}
 )
 
 func main() {
 	var cfg config
 
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
-
-	// default maxOpenConns for PSQL is 100, and ideally maxIdleConns == maxOpenConns
-	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-cons", 25, "PostgreSQL max idle connections")
-	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
-
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
-
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", "1142b361cbb2c4", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "c910bb46b0730d", "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <souvik@example.com>", "SMTP sender")
-
-	flag.Func("cors-trusted-origins", "trusted CORS origins (space seperated)", func(val string) error {
-		// Fields(s) splits the string s on spaces and returns a list/slice
-		cfg.cors.trustedOrigins = strings.Fields(val)
-		return nil
-	})
+	fs, configFile, displayVersion := newFlagSet(&cfg, flag.ExitOnError)
+	fs.Parse(os.Args[1:])
 
-	displayVersion := flag.Bool("version", false, "Display version and exit")
-
-	flag.Parse()
-
-	// if true this will just print our version number and exit
+	// if true this will just print our version number and exit, before any
+	// config is loaded so it works without a valid DSN or config file
 	if *displayVersion {
 		fmt.Printf("Version:\t%s\n", version)
+		if revisionTime := vcs.RevisionTime(); revisionTime != "" {
+			fmt.Printf("Revision time:\t%s\n", revisionTime)
+		}
+		fmt.Printf("Modified:\t%t\n", vcs.Modified())
 		os.Exit(0)
 	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	err := loadConfig(fs, *configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	db, err := openDB(cfg)
+	err = validateConfig(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	logLevel := new(slog.LevelVar)
+
+	logger, err := newLogger(cfg, logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	db, err := openDB(cfg, logger)
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
@@ -105,11 +182,14 @@ func main() {
 	defer db.Close()
 	logger.Info("db connection established")
 
-	mailer, err := mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+	appMailer, err := newMailer(cfg, logger)
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
+	if cfg.smtp.disabled {
+		logger.Warn("smtp disabled, emails will be logged instead of sent")
+	}
 
 	expvar.NewString("version").Set(version)
 	// publishes the number of active goroutines
@@ -124,41 +204,365 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	models := data.NewModels(db, cfg.db.queryTimeout)
+
+	err = checkPermissions(models, cfg.users.defaultPermissions)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	if cfg.movies.unique {
+		err = models.Movies.EnsureUniqueTitleYear()
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer,
+		config:     cfg,
+		logger:     logger,
+		db:         db,
+		models:     models,
+		mailQueue:  mailer.NewQueue(appMailer, logger, cfg.smtp.workers, cfg.smtp.queue),
+		logLevel:   logLevel,
+		configFile: *configFile,
+	}
+	app.live.Store(newLiveConfig(cfg))
+
+	if cfg.metrics.enabled {
+		app.prom = newPromMetrics(db)
+	}
+
+	shutdownTracing, err := setupTracing(context.Background(), cfg.otel.endpoint)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 
 	// mux := http.NewServeMux()
 	// mux.HandleFunc("/v1/healthcheck", app.healthCheckHandler)
 	err = app.serve()
+
+	// flush spans still buffered in the exporter
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if tracingErr := shutdownTracing(ctx); tracingErr != nil {
+		logger.Error(tracingErr.Error())
+	}
+
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
 }
 
-func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
+// newFlagSet defines every command-line flag, filling in cfg when the set is parsed.
+// It is used once at startup and again for each SIGHUP reload
+func newFlagSet(cfg *config, errorHandling flag.ErrorHandling) (fs *flag.FlagSet, configFile *string, displayVersion *bool) {
+	fs = flag.NewFlagSet(os.Args[0], errorHandling)
+
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+
+	fs.DurationVar(&cfg.http.idleTimeout, "http-idle-timeout", time.Minute, "HTTP keep-alive idle timeout")
+	fs.DurationVar(&cfg.http.readTimeout, "http-read-timeout", 5*time.Second, "HTTP timeout for reading the whole request")
+	fs.DurationVar(&cfg.http.readHeaderTimeout, "http-read-header-timeout", 5*time.Second, "HTTP timeout for reading request headers")
+	fs.DurationVar(&cfg.http.writeTimeout, "http-write-timeout", 10*time.Second, "HTTP timeout for writing the response")
+	fs.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "Graceful shutdown timeout")
+	fs.DurationVar(&cfg.handlerTimeout, "handler-timeout", 8*time.Second, "Longest a request may take before a 503 is sent, 0 disables it (keep it below -http-write-timeout)")
+	fs.DurationVar(&cfg.tokenCleanup, "token-cleanup-interval", time.Hour, "How often expired tokens are purged, 0 disables it")
+
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN, overrides the -db-host, -db-port, -db-user, -db-password, -db-name and -db-sslmode flags")
+	fs.StringVar(&cfg.db.host, "db-host", "localhost", "PostgreSQL host, used when -db-dsn is empty")
+	fs.IntVar(&cfg.db.port, "db-port", 5432, "PostgreSQL port, used when -db-dsn is empty")
+	fs.StringVar(&cfg.db.user, "db-user", "greenlight", "PostgreSQL user, used when -db-dsn is empty")
+	// set it with GREENLIGHT_DB_PASSWORD so it doesn't show up in the process args
+	fs.StringVar(&cfg.db.password, "db-password", "", "PostgreSQL password, used when -db-dsn is empty")
+	fs.StringVar(&cfg.db.name, "db-name", "greenlight", "PostgreSQL database name, used when -db-dsn is empty")
+	fs.StringVar(&cfg.db.sslmode, "db-sslmode", "disable", "PostgreSQL sslmode (disable|allow|prefer|require|verify-ca|verify-full), used when -db-dsn is empty")
+	// default maxOpenConns for PSQL is 100, and ideally maxIdleConns == maxOpenConns
+	cfg.db.maxOpenConns = 25
+	fs.Func("db-max-open-conns", `PostgreSQL max open connections, or "auto" to size it from the CPU count (default 25)`, func(val string) error {
+		if val == "auto" {
+			cfg.db.autoOpenConns = true
+			return nil
+		}
+
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return errors.New(`must be an integer or "auto"`)
+		}
+		cfg.db.maxOpenConns = n
+		cfg.db.autoOpenConns = false
+		return nil
+	})
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-cons", 25, "PostgreSQL max idle connections")
+	fs.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+	// per query deadline, unrelated to db-max-idle-time which only closes idle connections in the pool
+	fs.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 3*time.Second, "PostgreSQL timeout for each query")
+	fs.IntVar(&cfg.db.retries, "db-connect-retries", 5, "PostgreSQL connection attempts at startup before giving up")
+	fs.DurationVar(&cfg.db.backoff, "db-connect-backoff", time.Second, "PostgreSQL wait after the first failed connection attempt, doubled after each one")
+
+	fs.BoolVar(&cfg.proxy.trust, "trust-proxy", false, "Take the client ip from X-Forwarded-For or X-Real-IP when sent by a trusted proxy")
+	cfg.proxy.trustedProxies = defaultTrustedProxies
+	fs.Func("trusted-proxies", "CIDR ranges of trusted proxies (space seperated, default loopback and private ranges)", func(val string) error {
+		cfg.proxy.trustedProxies = nil
+		for _, field := range strings.Fields(val) {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return err
+			}
+			cfg.proxy.trustedProxies = append(cfg.proxy.trustedProxies, prefix.Masked())
+		}
+		return nil
+	})
+
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	fs.StringVar(&cfg.limiter.mode, "limiter-mode", "per-ip", "Rate limiter mode (per-ip|global|both)")
+	fs.Float64Var(&cfg.limiter.globalRPS, "limiter-global-rps", 100, "Rate limiter maximum requests per second across all clients, in global and both modes")
+	fs.IntVar(&cfg.limiter.globalBurst, "limiter-global-burst", 200, "Rate limiter maximum burst across all clients, in global and both modes")
+	fs.Float64Var(&cfg.limiter.authRPS, "limiter-auth-rps", 1, "Rate limiter maximum requests per second for the token and password endpoints")
+	fs.IntVar(&cfg.limiter.authBurst, "limiter-auth-burst", 3, "Rate limiter maximum burst for the token and password endpoints")
+
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	// no defaults for the credentials, set them with the flags or GREENLIGHT_SMTP_USERNAME and GREENLIGHT_SMTP_PASSWORD
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <souvik@example.com>", "SMTP sender")
+	fs.DurationVar(&cfg.smtp.timeout, "smtp-timeout", 5*time.Second, "SMTP timeout for each send attempt")
+	fs.IntVar(&cfg.smtp.retries, "smtp-retries", 3, "SMTP send attempts before giving up")
+	fs.DurationVar(&cfg.smtp.backoff, "smtp-backoff", 500*time.Millisecond, "SMTP wait between failed send attempts")
+	fs.IntVar(&cfg.smtp.workers, "smtp-workers", 4, "SMTP worker goroutines sending queued emails")
+	fs.IntVar(&cfg.smtp.queue, "smtp-queue-size", 100, "SMTP maximum number of queued emails")
+	fs.BoolVar(&cfg.smtp.disabled, "smtp-disabled", false, "Discard emails instead of sending them over SMTP")
+
+	fs.Func("cors-trusted-origins", "trusted CORS origins (space seperated)", func(val string) error {
+		// Fields(s) splits the string s on spaces and returns a list/slice
+		cfg.cors.trustedOrigins = strings.Fields(val)
+		return nil
+	})
+
+	// every newly registered user gets these, unless overridden by the flag
+	cfg.users.defaultPermissions = []string{"movies:read"}
+	fs.Func("default-permissions", `permissions granted to new users (space seperated, default "movies:read")`, func(val string) error {
+		cfg.users.defaultPermissions = strings.Fields(val)
+		return nil
+	})
+	fs.BoolVar(&cfg.users.emailRFC, "email-rfc", false, "Validate emails as RFC 5322 addresses with IDNA domains instead of the stricter regex")
+
+	fs.BoolVar(&cfg.metrics.enabled, "enable-metrics", false, "Serve Prometheus metrics on /metrics")
+	fs.StringVar(&cfg.otel.endpoint, "otel-endpoint", "", "OpenTelemetry OTLP/HTTP endpoint for traces, eg http://localhost:4318 (tracing is off when empty)")
+
+	fs.BoolVar(&cfg.compression.enabled, "enable-compression", true, "Gzip responses for clients that accept it")
+	fs.IntVar(&cfg.compression.minSize, "compression-min-size", 1024, "Smallest response body in bytes worth compressing")
+
+	fs.IntVar(&cfg.movies.minYear, "movie-min-year", data.MinMovieYear, "Earliest year a movie may have")
+	fs.BoolVar(&cfg.movies.unique, "enforce-unique-movies", false, "Reject movies with the same title and year as an existing one, creates a unique index at startup")
+
+	fs.StringVar(&cfg.maintenance.mode, "maintenance-mode", "read-only", "Maintenance mode SIGUSR1 toggles on (read-only|closed)")
+	fs.DurationVar(&cfg.maintenance.retryAfter, "maintenance-retry-after", 2*time.Minute, "Retry-After sent with responses refused for maintenance")
+
+	fs.StringVar(&cfg.posters.dir, "poster-dir", "./uploads/posters", "Directory movie poster uploads are stored in")
+	fs.Int64Var(&cfg.posters.maxSize, "poster-max-size", 2<<20, "Largest movie poster upload in bytes")
+
+	fs.StringVar(&cfg.log.level, "log-level", "info", "Log level (debug|info|warn|error)")
+	fs.StringVar(&cfg.log.format, "log-format", "text", "Log format (text|json)")
+	fs.BoolVar(&cfg.accessLog, "access-log", false, "Log every completed request")
+
+	displayVersion = fs.Bool("version", false, "Display version and exit")
+	configFile = fs.String("config", "", "JSON config file, values are overridden by GREENLIGHT_* env vars and flags")
+
+	return fs, configFile, displayVersion
+}
+
+// validateConfig catches flag values which parse fine but aren't usable
+func validateConfig(cfg config) error {
+	switch cfg.limiter.mode {
+	case "per-ip", "global", "both":
+	default:
+		return fmt.Errorf("invalid -limiter-mode %q", cfg.limiter.mode)
+	}
+
+	switch cfg.db.sslmode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		return fmt.Errorf("invalid -db-sslmode %q", cfg.db.sslmode)
+	}
+
+	_, err := parseMaintenanceMode(cfg.maintenance.mode)
+	if err != nil {
+		return err
+	}
+
+	if cfg.movies.minYear < 1 || cfg.movies.minYear > time.Now().Year() {
+		return fmt.Errorf("invalid -movie-min-year %d, it must be between 1 and the current year", cfg.movies.minYear)
+	}
+
+	return nil
+}
+
+// databaseDSN returns -db-dsn when it is set, and otherwise builds a postgres:// url
+// from the individual -db-* flags, escaping the user and password as needed
+func databaseDSN(cfg config) string {
+	if cfg.db.dsn != "" {
+		return cfg.db.dsn
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		Host:     net.JoinHostPort(cfg.db.host, strconv.Itoa(cfg.db.port)),
+		Path:     "/" + cfg.db.name,
+		RawQuery: url.Values{"sslmode": {cfg.db.sslmode}}.Encode(),
+	}
+
+	if cfg.db.password != "" {
+		u.User = url.UserPassword(cfg.db.user, cfg.db.password)
+	} else if cfg.db.user != "" {
+		u.User = url.User(cfg.db.user)
+	}
+
+	return u.String()
+}
+
+// newLogger builds the application logger from the -log-level and -log-format flags
+// The level is read from level on every log call, so a SIGHUP reload can change it
+func newLogger(cfg config, level *slog.LevelVar) (*slog.Logger, error) {
+	// UnmarshalText accepts the level names case insensitively, eg "debug" or "WARN"
+	err := level.UnmarshalText([]byte(cfg.log.level))
+	if err != nil {
+		return nil, fmt.Errorf("invalid -log-level %q", cfg.log.level)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch cfg.log.format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stdout, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stdout, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid -log-format %q", cfg.log.format)
+	}
+}
+
+// newMailer builds the SMTP mailer, or a LogMailer when -smtp-disabled is set
+func newMailer(cfg config, logger *slog.Logger) (mailer.Mailer, error) {
+	if cfg.smtp.disabled {
+		return mailer.NewLogMailer(logger), nil
+	}
+
+	if cfg.smtp.username == "" || cfg.smtp.password == "" {
+		return nil, errors.New("smtp credentials missing: set -smtp-username and -smtp-password (or GREENLIGHT_SMTP_USERNAME and GREENLIGHT_SMTP_PASSWORD), or use -smtp-disabled")
+	}
+
+	return mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender,
+		mailer.WithTimeout(cfg.smtp.timeout),
+		mailer.WithRetries(cfg.smtp.retries),
+		mailer.WithBackoff(cfg.smtp.backoff),
+	)
+}
+
+// checkPermissions makes sure every code in codes exists in the permissions table,
+// so a typo in -default-permissions fails at startup instead of on each registration
+func checkPermissions(models data.Models, codes []string) error {
+	known, err := models.Permissions.GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		if !known.Include(code) {
+			return fmt.Errorf("invalid -default-permissions: unknown permission %q", code)
+		}
+	}
+
+	return nil
+}
+
+// openDB opens the connection pool and pings the db, retrying with exponential
+// backoff so a db that is still starting up doesn't take the api down with it
+func openDB(cfg config, logger *slog.Logger) (*sql.DB, error) {
+	db, err := sql.Open("postgres", databaseDSN(cfg))
 	if err != nil {
 		return nil, err
 	}
 
-	db.SetMaxOpenConns(cfg.db.maxOpenConns)
+	maxOpenConns := cfg.db.maxOpenConns
+	if cfg.db.autoOpenConns {
+		maxOpenConns = autoMaxOpenConns()
+	}
+
+	db.SetMaxOpenConns(maxOpenConns)
 	db.SetMaxIdleConns(cfg.db.maxIdleConns)
 	db.SetConnMaxIdleTime(cfg.db.maxIdleTime)
 
+	attempts := max(cfg.db.retries, 1)
+	backoff := cfg.db.backoff
+
+	for attempt := 1; ; attempt++ {
+		err = pingDB(db)
+		if err == nil {
+			fitPoolToServer(db, maxOpenConns, logger)
+			return db, nil
+		}
+
+		if attempt >= attempts {
+			break
+		}
+
+		logger.Warn("db connection failed, retrying", "attempt", attempt, "backoff", backoff.String(), "error", err.Error())
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	db.Close()
+	return nil, fmt.Errorf("db connection failed after %d attempts: %w", attempts, err)
+}
+
+// maxAutoOpenConns caps the pool size picked by -db-max-open-conns=auto
+const maxAutoOpenConns = 100
+
+// autoMaxOpenConns sizes the pool at 4 connections per CPU, up to maxAutoOpenConns
+func autoMaxOpenConns() int {
+	return min(4*runtime.NumCPU(), maxAutoOpenConns)
+}
+
+// fitPoolToServer lowers the pool size when it is more than the server accepts, ie
+// max_connections less the slots reserved for superusers. The pool size in use is
+// logged either way. If the settings can't be read the pool is left as is
+func fitPoolToServer(db *sql.DB, maxOpenConns int, logger *slog.Logger) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// ctx has timeout of 5s, PingContext will try to establish a connection with a timeout of 5s
-	err = db.PingContext(ctx)
+	var available int
+	err := db.QueryRowContext(ctx, `
+		SELECT current_setting('max_connections')::int - current_setting('superuser_reserved_connections')::int`).Scan(&available)
 	if err != nil {
-		db.Close()
-		return nil, err
+		logger.Warn("could not read the db max_connections, pool size not checked", "error", err.Error())
+		logger.Info("db pool configured", "max_open_conns", maxOpenConns)
+		return
+	}
+
+	// 0 means unlimited to database/sql
+	if maxOpenConns <= 0 || maxOpenConns > available {
+		logger.Warn("db pool larger than the server allows, lowering it", "max_open_conns", maxOpenConns, "server_available", available)
+		maxOpenConns = available
+		db.SetMaxOpenConns(maxOpenConns)
 	}
 
-	return db, nil
+	logger.Info("db pool configured", "max_open_conns", maxOpenConns)
+}
+
+// pingDB makes a single attempt to reach the db
+func pingDB(db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// ctx has timeout of 5s, PingContext will try to establish a connection with a timeout of 5s
+	return db.PingContext(ctx)
 }
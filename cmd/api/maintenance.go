@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// maintenanceMode is what the maintenance middleware lets through
+type maintenanceMode int32
+
+const (
+	maintenanceOff maintenanceMode = iota
+	// only safe methods (GET, HEAD, OPTIONS) are served
+	maintenanceReadOnly
+	// every request but the healthchecks gets a 503
+	maintenanceClosed
+)
+
+func (m maintenanceMode) String() string {
+	switch m {
+	case maintenanceReadOnly:
+		return "read-only"
+	case maintenanceClosed:
+		return "closed"
+	default:
+		return "off"
+	}
+}
+
+// parseMaintenanceMode parses the -maintenance-mode flag, off is not accepted as
+// that is always what SIGUSR1 switches back to
+func parseMaintenanceMode(s string) (maintenanceMode, error) {
+	switch s {
+	case "read-only":
+		return maintenanceReadOnly, nil
+	case "closed":
+		return maintenanceClosed, nil
+	default:
+		return maintenanceOff, fmt.Errorf("invalid -maintenance-mode %q", s)
+	}
+}
+
+// maintenanceState is the current maintenance mode
+func (app *application) maintenanceState() maintenanceMode {
+	return maintenanceMode(app.inMaintenance.Load())
+}
+
+// handleMaintenanceToggle switches maintenance on with -maintenance-mode, or back off,
+// each time a SIGUSR1 comes in
+func (app *application) handleMaintenanceToggle() {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+
+	go func() {
+		for range usr1 {
+			next := maintenanceOff
+			if app.maintenanceState() == maintenanceOff {
+				// validated at startup
+				next, _ = parseMaintenanceMode(app.config.maintenance.mode)
+			}
+
+			app.inMaintenance.Store(int32(next))
+			app.logger.Info("maintenance mode changed", "mode", next.String())
+		}
+	}()
+}
+
+// maintenanceExempt reports whether a path is served whatever the maintenance mode,
+// the healthchecks keep answering so orchestration doesn't restart the server
+func maintenanceExempt(path string) bool {
+	return strings.HasPrefix(path, "/v1/healthcheck") || path == "/metrics" || path == "/debug/vars"
+}
+
+// maintenance answers 503 with a Retry-After header for the requests the current
+// maintenance mode doesn't allow
+func (app *application) maintenance(next http.Handler) http.Handler {
+	retryAfter := strconv.Itoa(int(app.config.maintenance.retryAfter.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mode := app.maintenanceState()
+
+		blocked := false
+		switch mode {
+		case maintenanceReadOnly:
+			blocked = r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+		case maintenanceClosed:
+			blocked = true
+		}
+
+		if blocked && !maintenanceExempt(r.URL.Path) {
+			w.Header().Set("Retry-After", retryAfter)
+			app.maintenanceResponse(w, r, mode)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"expvar"
 	"fmt"
+	"log/slog"
+	"maps"
 	"net/http"
 	"strconv"
 	"strings"
@@ -12,15 +15,16 @@ import (
 
 	"github.com/souvikmndl/greenlight-api/internal/data"
 	"github.com/souvikmndl/greenlight-api/internal/validator"
-	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 )
 
 // wrapping existing http.ResponseWriter interface to capture status codes
+// and response sizes, used by the metrics and logRequest middleware
 type metricsResponseWriter struct {
 	wrapped       http.ResponseWriter
 	statusCode    int
 	headerWritten bool
+	bytesWritten  int
 }
 
 func newMetricsResponseWriter(w http.ResponseWriter) *metricsResponseWriter {
@@ -50,7 +54,10 @@ func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
 // Calling this will automatically write any resp headers
 func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
 	mw.headerWritten = true
-	return mw.wrapped.Write(b)
+
+	n, err := mw.wrapped.Write(b)
+	mw.bytesWritten += n
+	return n, err
 }
 
 // Unwrap returns the wrapped http.ResponseWriter
@@ -58,6 +65,25 @@ func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
 	return mw.wrapped
 }
 
+// requestID tags every request with an id, taken from an incoming X-Request-ID header
+// or generated, and stores a logger carrying that id in the request context
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		// only trust ids from upstream that are of a sensible size and printable
+		if id == "" || len(id) > 128 || strings.ContainsFunc(id, func(c rune) bool { return c < 0x21 || c > 0x7e }) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		r = app.contextSetLogger(r, app.logger.With("request_id", id))
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// deferred func will be called after panic
@@ -82,6 +108,45 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// timeoutExempt lists the paths that may run longer than -handler-timeout
+var timeoutExempt = map[string]bool{
+	"/v1/movies/export": true,
+}
+
+// timeout answers 503 when a request takes longer than -handler-timeout. The request
+// context is cancelled at the deadline, so a db query still running is cancelled too.
+// http.TimeoutHandler buffers the response, which is why streaming paths are exempt
+func (app *application) timeout(next http.Handler) http.Handler {
+	if app.config.handlerTimeout <= 0 {
+		return next
+	}
+
+	// TimeoutHandler only takes a fixed body, so the error is always sent as JSON
+	body, _ := json.Marshal(envelope{"error": "request timed out"})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if timeoutExempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// next writes its headers to a buffer which then replaces the keys it set
+		// on w, so a Vary added by next would drop the ones compress and enableCORS
+		// already added. The buffer starts out as a copy of w's headers instead
+		outer := w.Header().Clone()
+		seeded := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			maps.Copy(w.Header(), outer)
+			next.ServeHTTP(w, r)
+		})
+
+		// only sent as is with the timeout response, set after the copy so it
+		// doesn't stop http.ServeContent sniffing the poster content type
+		w.Header().Set("Content-Type", "application/json")
+
+		http.TimeoutHandler(seeded, app.config.handlerTimeout, string(body)).ServeHTTP(w, r)
+	})
+}
+
 /*
 func (app *application) rateLimit(next http.Handler) http.Handler {
 	// code in this section will run only once, when we wrap something with the mw
@@ -114,62 +179,143 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		return next
 	}
 
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
-
 	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client) // for client based rate limiting
+		perIP *ipLimiter
+		// one bucket shared by every request, it caps total throughput however many ips there are
+		global *rate.Limiter
 	)
 
-	// clean up client ip entries that are older than 3 seconds to allow for fresh requests
-	go func() {
-		for {
-			time.Sleep(time.Minute)
+	if app.config.limiter.mode != "global" {
+		perIP = newIPLimiter(app.logger)
+	}
 
-			// Lock the mutex to prevent any rate limiter checks happening while clean up
-			mu.Lock()
+	if app.config.limiter.mode != "per-ip" {
+		global = rate.NewLimiter(rate.Limit(app.config.limiter.globalRPS), app.config.limiter.globalBurst)
+	}
 
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the limits are read per request as a SIGHUP reload may change them
+		live := app.live.Load()
 
-			mu.Unlock()
+		// in both mode the per-ip bucket goes first, so a client already over its own
+		// limit doesn't use up tokens from the global one
+		if perIP != nil && !perIP.allow(app.realIP(r), rate.Limit(live.limiterRPS), live.limiterBurst) {
+			app.rateLimitExceededResponse(w, r)
+			return
 		}
-	}()
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// fetch real IP of client, sometimes it might be hidden behind proxies
-		ip := realip.FromRequest(r) // looks for the X-Forwarded-For or X-Real-IP header
+		if global != nil && !allowAt(global, rate.Limit(live.globalRPS), live.globalBurst) {
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
 
-		// Lock the rate limiter as requests are concurrently processed
-		mu.Lock()
+		next.ServeHTTP(w, r)
+	})
+}
 
-		// check to see if the client IP already exists in the map. if it doesnt, then
-		// initialise a new rate limiter and add to map for the IP
-		if _, found := clients[ip]; !found {
-			clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
-		}
+// rateLimitFor adds a per-ip limit of its own to a single route, on top of the
+// global rateLimit. Every call gets an independent set of buckets, so a client
+// hammering one route isn't slowed down on the others
+func (app *application) rateLimitFor(rps float64, burst int, next http.HandlerFunc) http.HandlerFunc {
+	if !app.config.limiter.enabled {
+		return next
+	}
 
-		clients[ip].lastSeen = time.Now()
+	limiter := newIPLimiter(app.logger)
 
-		// call the rate limiter check for this client only
-		if !clients[ip].limiter.Allow() {
-			mu.Unlock()
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.allow(app.realIP(r), rate.Limit(rps), burst) {
 			app.rateLimitExceededResponse(w, r)
 			return
 		}
 
-		// unlock mutex for the else path
-		// we dont use defer to unlock as that would mean that the mutex isnt unlocked untill
-		// all the handlers downstream of this mw have also returned
-		mu.Unlock()
-		next.ServeHTTP(w, r)
-	})
+		next(w, r)
+	}
+}
+
+// ipLimiter keeps a token bucket per client ip
+type ipLimiter struct {
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	clients map[string]*ipClient // for client based rate limiting
+}
+
+type ipClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newIPLimiter creates an empty ipLimiter, clients unseen for 3 minutes are forgotten
+func newIPLimiter(logger *slog.Logger) *ipLimiter {
+	l := &ipLimiter{
+		logger:  logger,
+		clients: make(map[string]*ipClient),
+	}
+
+	// clean up client ip entries that are older than 3 minutes to allow for fresh requests.
+	// recoverPanic doesn't cover this goroutine, sweep recovers on its own so a panic
+	// only costs one pass instead of the whole process
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			l.sweep()
+		}
+	}()
+
+	return l
+}
+
+// sweep makes one clean up pass, a panic is logged and the next pass runs as usual
+func (l *ipLimiter) sweep() {
+	defer func() {
+		if err := recover(); err != nil {
+			l.logger.Error("rate limiter clean up panicked", "error", fmt.Sprintf("%v", err))
+		}
+	}()
+
+	// Lock the mutex to prevent any rate limiter checks happening while clean up,
+	// the deferred unlock runs before the recover so a panic can't leave it locked
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for ip, client := range l.clients {
+		if time.Since(client.lastSeen) > 3*time.Minute {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// allow takes a token from the bucket of ip, which allows rps requests per second with
+// bursts of up to burst. It reports false when there is no token left
+func (l *ipLimiter) allow(ip string, rps rate.Limit, burst int) bool {
+	// Lock the rate limiter as requests are concurrently processed
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// check to see if the client IP already exists in the map. if it doesnt, then
+	// initialise a new rate limiter and add to map for the IP
+	if _, found := l.clients[ip]; !found {
+		l.clients[ip] = &ipClient{limiter: rate.NewLimiter(rps, burst)}
+	}
+
+	l.clients[ip].lastSeen = time.Now()
+
+	// call the rate limiter check for this client only
+	return allowAt(l.clients[ip].limiter, rps, burst)
+}
+
+// allowAt is limiter.Allow after bringing the limiter up to date with rps and burst,
+// they only differ after a reload changed the limits
+func allowAt(limiter *rate.Limiter, rps rate.Limit, burst int) bool {
+	if limiter.Limit() != rps {
+		limiter.SetLimit(rps)
+	}
+	if limiter.Burst() != burst {
+		limiter.SetBurst(burst)
+	}
+
+	return limiter.Allow()
 }
 
 func (app *application) authenticate(next http.Handler) http.Handler {
@@ -190,18 +336,17 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// if auth header is present, we extract to verify user details
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidCredentialsResponse(w, r)
+		token, err := app.readBearerToken(r)
+		if err != nil {
+			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
-		token := headerParts[1]
 		// validate token
 		v := validator.New()
 		data.ValidateTokenPlaintext(v, token)
 		if !v.Valid() {
-			app.invalidCredentialsResponse(w, r)
+			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
@@ -253,26 +398,26 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
-// for rbac
+// for rbac, checks if an activated user has the permission code
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
-	// fn := func(w http.ResponseWriter, r *http.Request) {
-	// 	user := app.contextGetUser(r)
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
 
-	// 	permissions, err := app.models.Permissions.GetAllForuser(user.ID)
-	// 	if err != nil {
-	// 		app.serverErrorResponse(w, r, err)
-	// 		return
-	// 	}
+		permissions, err := app.models.Permissions.GetAllForuser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 
-	// 	if !permissions.Include(code) {
-	// 		app.notPermittedResponse(w, r)
-	// 		return
-	// 	}
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
 
-	// 	next.ServeHTTP(w, r)
-	// }
+		next.ServeHTTP(w, r)
+	}
 
-	return app.requireActivatedUser(next)
+	return app.requireActivatedUser(fn)
 }
 
 // Allows cors for whitelisted origins
@@ -289,8 +434,11 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		origin := r.Header.Get("Origin")
 
 		if origin != "" {
-			for i := range app.config.cors.trustedOrigins {
-				if origin == app.config.cors.trustedOrigins[i] {
+			// loaded per request, a SIGHUP reload may change the list
+			trustedOrigins := app.live.Load().trustedOrigins
+
+			for i := range trustedOrigins {
+				if origin == trustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
 
 					// Options request is for preflight cors, it asks for which methods and headers
@@ -299,6 +447,10 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
 						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+						// preflight requests are answered here, there is no route to pass them on to
+						w.WriteHeader(http.StatusOK)
+						return
 					}
 
 					break
@@ -310,22 +462,25 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) metrics(next http.Handler) http.Handler {
-	// initialise the new expvar variables when the mw chain is first built
-	var (
-		totalRequestsReceived           = expvar.NewInt("total_requests_received")
-		totalResponsesSent              = expvar.NewInt("total_responses_sent")
-		totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_ms")
-		totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status")
-	)
+// the expvar variables of the metrics mw. expvar panics when a name is published
+// twice, so they live here rather than in metrics, which runs for every routes()
+// built, eg once per application in the handler tests
+var (
+	totalRequestsReceived           = expvar.NewInt("total_requests_received")
+	totalResponsesSent              = expvar.NewInt("total_responses_sent")
+	totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
+	totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status")
+)
 
+func (app *application) metrics(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
 		totalRequestsReceived.Add(1)
 		mw := newMetricsResponseWriter(w)
 
-		next.ServeHTTP(w, r)
+		// pass our wrapper down the chain so it sees the status code written by the handlers
+		next.ServeHTTP(mw, r)
 		totalResponsesSent.Add(1)
 
 		totalResponsesSentByStatus.Add(strconv.Itoa(mw.statusCode), 1)
@@ -333,3 +488,32 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		totalProcessingTimeMicroseconds.Add(duration)
 	})
 }
+
+// logRequest writes an access log line for every completed request, other than
+// scrapes of /debug/vars which would only add noise. Enabled by -access-log
+func (app *application) logRequest(next http.Handler) http.Handler {
+	if !app.config.accessLog {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/debug/vars" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		mw := newMetricsResponseWriter(w)
+
+		next.ServeHTTP(mw, r)
+
+		app.contextGetLogger(r).Info("request completed",
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"remote_addr", r.RemoteAddr,
+			"status", mw.statusCode,
+			"size", mw.bytesWritten,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
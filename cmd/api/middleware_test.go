@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// corsRequest is a gzip accepting request from a trusted origin, the headers which
+// make compress and enableCORS add their Vary values
+func corsRequest(urlPath string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, urlPath, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Origin", "http://localhost:9000")
+	return r
+}
+
+func TestTimeoutKeepsHeaders(t *testing.T) {
+	// adds its Vary the way authenticate does, inside the timeout
+	fast := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status": "available"}`))
+	})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+		<-r.Context().Done()
+	})
+
+	tests := []struct {
+		name           string
+		handler        http.Handler
+		handlerTimeout time.Duration
+		wantStatus     int
+		wantBody       string
+		wantVary       []string
+	}{
+		{
+			name:           "no timeout",
+			handler:        fast,
+			handlerTimeout: 0,
+			wantStatus:     http.StatusOK,
+			wantBody:       `{"status": "available"}`,
+			wantVary:       []string{"Accept-Encoding", "Origin", "Access-Control-Request-Method", "Authorization"},
+		},
+		{
+			name:           "in time",
+			handler:        fast,
+			handlerTimeout: time.Second,
+			wantStatus:     http.StatusOK,
+			wantBody:       `{"status": "available"}`,
+			wantVary:       []string{"Accept-Encoding", "Origin", "Access-Control-Request-Method", "Authorization"},
+		},
+		{
+			name:           "timed out",
+			handler:        slow,
+			handlerTimeout: 20 * time.Millisecond,
+			wantStatus:     http.StatusServiceUnavailable,
+			wantBody:       `{"error":"request timed out"}`,
+			wantVary:       []string{"Accept-Encoding", "Origin", "Access-Control-Request-Method"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.handlerTimeout = tt.handlerTimeout
+			app.config.cors.trustedOrigins = []string{"http://localhost:9000"}
+			app.live.Store(newLiveConfig(app.config))
+
+			// the same order as in routes
+			h := app.compress(app.enableCORS(app.timeout(tt.handler)))
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, corsRequest("/v1/healthcheck/live"))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("got body %q, want %q", got, tt.wantBody)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("got Content-Type %q, want application/json", got)
+			}
+			if got := w.Header().Values("Vary"); !slices.Equal(got, tt.wantVary) {
+				t.Errorf("got Vary %q, want %q", got, tt.wantVary)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != "http://localhost:9000" {
+				t.Errorf("got Access-Control-Allow-Origin %q", got)
+			}
+		})
+	}
+}
+
+func TestRoutesVary(t *testing.T) {
+	want := []string{"Accept-Encoding", "Origin", "Access-Control-Request-Method", "Authorization"}
+
+	for _, handlerTimeout := range []time.Duration{0, 8 * time.Second} {
+		t.Run(handlerTimeout.String(), func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.handlerTimeout = handlerTimeout
+			app.config.cors.trustedOrigins = []string{"http://localhost:9000"}
+			app.live.Store(newLiveConfig(app.config))
+
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, corsRequest("/v1/healthcheck/live"))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+			}
+			if got := w.Header().Values("Vary"); !slices.Equal(got, want) {
+				t.Errorf("got Vary %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestIPLimiterSweepRecovers(t *testing.T) {
+	var logs bytes.Buffer
+
+	// built by hand rather than with newIPLimiter, so no sweep loop runs behind the test
+	l := &ipLimiter{
+		logger: slog.New(slog.NewTextHandler(&logs, nil)),
+		clients: map[string]*ipClient{
+			// a nil client makes the sweep panic on client.lastSeen
+			"198.51.100.1": nil,
+		},
+	}
+
+	// a panic escaping sweep would take the test binary down with it
+	l.sweep()
+
+	if !strings.Contains(logs.String(), "rate limiter clean up panicked") {
+		t.Errorf("the panic was not logged, got logs:\n%s", logs.String())
+	}
+
+	// the mutex was released on the way out
+	if !l.mu.TryLock() {
+		t.Fatal("the sweep left the mutex locked")
+	}
+	delete(l.clients, "198.51.100.1")
+	l.clients["198.51.100.2"] = &ipClient{lastSeen: time.Now().Add(-time.Hour)}
+	l.mu.Unlock()
+
+	// the limiter keeps working and the next pass sweeps as usual
+	if !l.allow("203.0.113.7", 2, 4) {
+		t.Error("allow refused a first request after the panic")
+	}
+
+	l.sweep()
+
+	if _, found := l.clients["198.51.100.2"]; found {
+		t.Error("the next sweep kept a client unseen for an hour")
+	}
+	if _, found := l.clients["203.0.113.7"]; !found {
+		t.Error("the next sweep dropped a client seen just now")
+	}
+}
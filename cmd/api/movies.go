@@ -1,9 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/souvikmndl/greenlight-api/internal/data"
 	"github.com/souvikmndl/greenlight-api/internal/validator"
@@ -11,10 +15,10 @@ import (
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+		Title   string        `json:"title"`
+		Year    int32         `json:"year"`
+		Runtime *data.Runtime `json:"runtime"`
+		Genres  []string      `json:"genres"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -30,19 +34,24 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Title:   input.Title,
 		Year:    input.Year,
 		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Genres:  data.NormalizeGenres(input.Genres),
 	}
 
 	v := validator.New()
 
-	if data.ValidateMovies(v, movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	if data.ValidateMovie(v, movie, int32(app.config.movies.minYear)); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(r.Context(), movie)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateMovie):
+			app.duplicateMovieResponse(w, r, "title")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -56,6 +65,80 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+// maxMovieBatch caps how many movies one batch request can create
+const maxMovieBatch = 1000
+
+// createMoviesBatchHandler creates up to maxMovieBatch movies in one transaction.
+// Validation errors are keyed by position, eg "movies[3].year"
+func (app *application) createMoviesBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Movies []struct {
+			Title   string        `json:"title"`
+			Year    int32         `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		} `json:"movies"`
+	}
+
+	// a full batch doesn't fit in the default 1MB
+	err := app.readJSONWithLimit(w, r, &input, 8*defaultMaxBodyBytes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.CheckCode(len(input.Movies) >= 1, "movies", validator.CodeTooShort, "must contain at least 1 movie")
+	v.CheckCode(len(input.Movies) <= maxMovieBatch, "movies", validator.CodeTooLong, fmt.Sprintf("must not contain more than %d movies", maxMovieBatch))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
+		return
+	}
+
+	movies := make([]*data.Movie, len(input.Movies))
+
+	for i, in := range input.Movies {
+		movies[i] = &data.Movie{
+			Title:   in.Title,
+			Year:    in.Year,
+			Runtime: in.Runtime,
+			Genres:  data.NormalizeGenres(in.Genres),
+		}
+
+		mv := validator.New()
+		data.ValidateMovie(mv, movies[i], int32(app.config.movies.minYear))
+		for _, detail := range mv.Details() {
+			v.AddErrorCode(fmt.Sprintf("movies[%d].%s", i, detail.Field), detail.Code, detail.Message)
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
+		return
+	}
+
+	// a client giving up on the request cancels the inserts and rolls the batch back
+	err = app.models.WithTx(r.Context(), func(models data.Models) error {
+		return models.Movies.InsertBatch(r.Context(), movies)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateMovie):
+			// the db doesn't say which one, it may clash with another movie in the batch
+			app.duplicateMovieResponse(w, r, "movies")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// using httprouter, all url params are passed into the context
 	// we can retrieve them in a slice using ParamsFromContext()
@@ -67,7 +150,15 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	v := validator.New()
+
+	runtimeFormat := app.readRuntimeFormat(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -78,7 +169,24 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// the version changes on every update so it makes a cheap ETag, the runtime
+	// format is part of it as the two formats are different representations
+	etag := fmt.Sprintf(`"v%d"`, movie.Version)
+	var output any = movie
+	if runtimeFormat == data.RuntimeFormatNumeric {
+		etag = fmt.Sprintf(`"v%d-%s"`, movie.Version, runtimeFormat)
+		output = movie.WithNumericRuntime()
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	if etagMatches(r, etag) {
+		app.notModifiedResponse(w, headers)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": output}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -91,7 +199,20 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	// clients can make the update conditional on the movie still being at the version
+	// they last saw, X-Expected-Version is optional and skipped when absent
+	var expectedVersion *int32
+	if header := r.Header.Get("X-Expected-Version"); header != "" {
+		version, err := strconv.ParseInt(header, 10, 32)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("X-Expected-Version header must be an integer"))
+			return
+		}
+		expectedVersion = new(int32)
+		*expectedVersion = int32(version)
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -102,6 +223,11 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if expectedVersion != nil && *expectedVersion != movie.Version {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	var input struct {
 		Title   *string       `json:"title"`
 		Year    *int32        `json:"year"`
@@ -124,23 +250,25 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
+		movie.Runtime = input.Runtime
 	}
 
 	if input.Genres != nil {
-		movie.Genres = input.Genres
+		movie.Genres = data.NormalizeGenres(input.Genres)
 	}
 
 	v := validator.New()
 
-	if data.ValidateMovies(v, movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	if data.ValidateMovie(v, movie, int32(app.config.movies.minYear)); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
 		return
 	}
 
 	err = app.models.Movies.Update(movie)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrDuplicateMovie):
+			app.duplicateMovieResponse(w, r, "title")
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -173,6 +301,14 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// the movie is gone either way, a poster left behind is only logged
+	for _, ext := range posterExtensions {
+		err = os.Remove(app.posterPath(id, ext))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			app.logError(r, err)
+		}
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -181,8 +317,10 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		Title         string
+		Genres        []string
+		CreatedAfter  time.Time
+		CreatedBefore time.Time
 		data.Filters
 	}
 
@@ -190,26 +328,194 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	qs := r.URL.Query()
 
 	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Genres = data.NormalizeGenres(app.readCSV(qs, "genres", []string{}))
+	input.CreatedAfter = app.readTime(qs, "created_after", v)
+	input.CreatedBefore = app.readTime(qs, "created_before", v)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 
 	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
 
+	runtimeFormat := app.readRuntimeFormat(qs, v)
+
+	if !input.CreatedAfter.IsZero() && !input.CreatedBefore.IsZero() {
+		v.Check(input.CreatedBefore.After(input.CreatedAfter), "created_before", "must be later than created_after")
+	}
+
+	// this has to run before GetAll, sortColumn panics on values missing from the safelist
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.CreatedAfter, input.CreatedBefore, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var output any = movies
+	if runtimeFormat == data.RuntimeFormatNumeric {
+		numeric := make([]data.NumericRuntimeMovie, len(movies))
+		for i, movie := range movies {
+			numeric[i] = movie.WithNumericRuntime()
+		}
+		output = numeric
+	}
+
+	headers := make(http.Header)
+	if links := paginationLinks(r, metadata); links != "" {
+		headers.Set("Link", links)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": output, "metadata": metadata}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// movieStatsHandler returns aggregate numbers for the whole movies collection
+func (app *application) movieStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.models.Movies.Stats()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// similarMoviesHandler lists movies similar to the one in the url, the number
+// returned is set with ?limit= (default 10, at most 50)
+func (app *application) similarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	limit := app.readInt(qs, "limit", 10, v)
+	v.CheckCode(limit > 0, "limit", validator.CodeTooSmall, "must be greater than zero")
+	v.CheckCode(limit <= 50, "limit", validator.CodeTooLarge, "must be a maximum of 50")
+
+	runtimeFormat := app.readRuntimeFormat(qs, v)
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movies, err := app.models.Movies.GetSimilar(r.Context(), movie, limit)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	var output any = movies
+	if runtimeFormat == data.RuntimeFormatNumeric {
+		numeric := make([]data.NumericRuntimeMovie, len(movies))
+		for i, movie := range movies {
+			numeric[i] = movie.WithNumericRuntime()
+		}
+		output = numeric
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": output}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// exportFlushEvery is how many movies exportMoviesHandler writes between flushes
+const exportFlushEvery = 100
+
+// exportMoviesHandler streams every movie as newline-delimited JSON, one movie per
+// line, straight from the db cursor instead of building the whole response first
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	runtimeFormat := app.readRuntimeFormat(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+
+	// an export can outlast -http-write-timeout, so the write deadline is lifted
+	// for this response, it is skipped by the timeout middleware for the same reason
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	started := false
+	written := 0
+
+	err = app.models.Movies.Export(r.Context(), func(movie *data.Movie) error {
+		// the status is only sent with the first movie, so a query which fails
+		// straight away still gets a proper error response
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+
+		var output any = movie
+		if runtimeFormat == data.RuntimeFormatNumeric {
+			output = movie.WithNumericRuntime()
+		}
+
+		err := enc.Encode(output)
+		if err != nil {
+			return err
+		}
+
+		written++
+		if written%exportFlushEvery == 0 {
+			// not every ResponseWriter can flush, the rows then go out when it fills up
+			err = rc.Flush()
+			if err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// the 200 and part of the body are already out, all we can do is log it
+		// and end the stream early, clients spot the truncation by the missing rows
+		app.logError(r, fmt.Errorf("movie export aborted after %d movies: %w", written, err))
+		return
+	}
+
+	// an empty table is still a valid, empty export
+	if !started {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/souvikmndl/greenlight-api/internal/data"
+)
+
+// insertTestMovie adds a movie straight through the model, app must be using the test database
+func insertTestMovie(t *testing.T, app *application, title string, year int32, genres ...string) *data.Movie {
+	t.Helper()
+
+	runtime := data.Runtime(107)
+	movie := &data.Movie{Title: title, Year: year, Runtime: &runtime, Genres: genres}
+
+	err := app.models.Movies.Insert(context.Background(), movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return movie
+}
+
+func TestShowMovieUnauthenticated(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	// turned away by requirePermission before the db is needed
+	res := ts.request(t, http.MethodGet, "/v1/movies/1", "", nil)
+	if res.status != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want %d", res.status, http.StatusUnauthorized)
+	}
+
+	if got := field(res.envelope(t), "error"); got != "you must be authenticated to access this resource" {
+		t.Errorf("got error %v", got)
+	}
+}
+
+func TestShowMovie(t *testing.T) {
+	app := newTestApplication(t)
+	useTestDB(t, app)
+	ts := newTestServer(t, app.routes())
+
+	_, token := newTestUser(t, app, "movies:read")
+	movie := insertTestMovie(t, app, "Moana", 2016, "animation", "adventure")
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantTitle  any
+	}{
+		{"found", fmt.Sprintf("/v1/movies/%d", movie.ID), http.StatusOK, "Moana"},
+		{"not found", fmt.Sprintf("/v1/movies/%d", movie.ID+1000), http.StatusNotFound, nil},
+		{"negative id", "/v1/movies/-1", http.StatusNotFound, nil},
+		{"non numeric id", "/v1/movies/abc", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.request(t, http.MethodGet, tt.path, "", bearer(token))
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", res.status, tt.wantStatus)
+			}
+
+			env := res.envelope(t)
+			if got := field(env, "movie", "title"); got != tt.wantTitle {
+				t.Errorf("got title %v, want %v", got, tt.wantTitle)
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				if got := field(env, "movie", "runtime"); got != "107 mins" {
+					t.Errorf("got runtime %v, want 107 mins", got)
+				}
+			} else if got := field(env, "error"); got != "the requested resource could not be found" {
+				t.Errorf("got error %v", got)
+			}
+		})
+	}
+}
+
+func TestCreateMovie(t *testing.T) {
+	app := newTestApplication(t)
+	useTestDB(t, app)
+	ts := newTestServer(t, app.routes())
+
+	_, token := newTestUser(t, app, "movies:read", "movies:write")
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantErrors map[string]string
+	}{
+		{
+			name:       "valid",
+			body:       `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure"]}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "missing title and genres",
+			body:       `{"year": 2016, "runtime": "107 mins"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantErrors: map[string]string{"title": "must be provided", "genres": "must be provided"},
+		},
+		{
+			name:       "future year",
+			body:       `{"title": "Moana", "year": 3000, "genres": ["animation"]}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantErrors: map[string]string{"year": "must not be in the future"},
+		},
+		{
+			name:       "badly formed runtime",
+			body:       `{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation"]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.request(t, http.MethodPost, "/v1/movies", tt.body, bearer(token))
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+
+			env := res.envelope(t)
+
+			switch tt.wantStatus {
+			case http.StatusCreated:
+				id, _ := field(env, "movie", "id").(json.Number)
+				if want := "/v1/movies/" + id.String(); res.headers.Get("Location") != want {
+					t.Errorf("got Location %q, want %q", res.headers.Get("Location"), want)
+				}
+				if got := field(env, "movie", "version"); got != json.Number("1") {
+					t.Errorf("got version %v, want 1", got)
+				}
+			case http.StatusUnprocessableEntity:
+				errs, _ := field(env, "error").(map[string]any)
+				if len(errs) != len(tt.wantErrors) {
+					t.Errorf("got errors %v, want %v", errs, tt.wantErrors)
+				}
+				for key, want := range tt.wantErrors {
+					if errs[key] != want {
+						t.Errorf("got %s error %v, want %q", key, errs[key], want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateMovieMalformedExpectedVersion(t *testing.T) {
+	app := newTestApplication(t)
+
+	// the header is checked before the movie is fetched, so the handler is called
+	// directly without a database behind it
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPatch, "/v1/movies/1", strings.NewReader(`{"title": "Moana 2"}`))
+	r.Header.Set("X-Expected-Version", "one")
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "id", Value: "1"}}))
+
+	app.updateMovieHandler(w, r)
+
+	res := testResponse{status: w.Code, headers: w.Header(), body: w.Body.Bytes()}
+	if res.status != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", res.status, http.StatusBadRequest)
+	}
+	if got := field(res.envelope(t), "error"); got != "X-Expected-Version header must be an integer" {
+		t.Errorf("got error %v", got)
+	}
+}
+
+func TestUpdateMovieExpectedVersion(t *testing.T) {
+	app := newTestApplication(t)
+	useTestDB(t, app)
+	ts := newTestServer(t, app.routes())
+
+	_, token := newTestUser(t, app, "movies:read", "movies:write")
+
+	tests := []struct {
+		name        string
+		header      string
+		wantStatus  int
+		wantVersion any
+	}{
+		{"matching", "1", http.StatusOK, json.Number("2")},
+		{"mismatching", "2", http.StatusPreconditionFailed, nil},
+		{"missing", "", http.StatusOK, json.Number("2")},
+		{"malformed", "1.0", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// every case starts from a fresh movie at version 1
+			movie := insertTestMovie(t, app, "Moana "+tt.name, 2016, "animation")
+
+			headers := bearer(token)
+			if tt.header != "" {
+				headers.Set("X-Expected-Version", tt.header)
+			}
+
+			res := ts.request(t, http.MethodPatch, fmt.Sprintf("/v1/movies/%d", movie.ID), `{"year": 2017}`, headers)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+
+			env := res.envelope(t)
+			if got := field(env, "movie", "version"); got != tt.wantVersion {
+				t.Errorf("got version %v, want %v", got, tt.wantVersion)
+			}
+
+			if tt.wantStatus == http.StatusPreconditionFailed {
+				if got := field(env, "error"); got != "the resource does not match the expected version" {
+					t.Errorf("got error %v", got)
+				}
+
+				// the update must not have gone through
+				stored, err := app.models.Movies.Get(context.Background(), movie.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored.Year != 2016 || stored.Version != 1 {
+					t.Errorf("got year %d version %d after a failed precondition, want 2016 and 1", stored.Year, stored.Version)
+				}
+			}
+		})
+	}
+}
+
+func TestShowMovieConditionalGet(t *testing.T) {
+	app := newTestApplication(t)
+	useTestDB(t, app)
+	ts := newTestServer(t, app.routes())
+
+	_, token := newTestUser(t, app, "movies:read")
+	movie := insertTestMovie(t, app, "Moana", 2016, "animation")
+	path := fmt.Sprintf("/v1/movies/%d", movie.ID)
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{"no header", "", http.StatusOK},
+		{"matching", `"v1"`, http.StatusNotModified},
+		{"non matching", `"v2"`, http.StatusOK},
+		{"other runtime format", `"v1-numeric"`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := bearer(token)
+			if tt.ifNoneMatch != "" {
+				headers.Set("If-None-Match", tt.ifNoneMatch)
+			}
+
+			res := ts.request(t, http.MethodGet, path, "", headers)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", res.status, tt.wantStatus)
+			}
+
+			if got := res.headers.Get("ETag"); got != `"v1"` {
+				t.Errorf("got ETag %q, want %q", got, `"v1"`)
+			}
+
+			if tt.wantStatus == http.StatusNotModified && len(res.body) != 0 {
+				t.Errorf("got a %d byte body on a 304", len(res.body))
+			}
+		})
+	}
+}
+
+func TestCreateMovieNormalizesGenres(t *testing.T) {
+	app := newTestApplication(t)
+	useTestDB(t, app)
+	ts := newTestServer(t, app.routes())
+
+	_, token := newTestUser(t, app, "movies:read", "movies:write")
+
+	body := `{"title": "Arrival", "year": 2016, "genres": ["Sci-Fi", " sci-fi", "DRAMA"]}`
+
+	res := ts.request(t, http.MethodPost, "/v1/movies", body, bearer(token))
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", res.status, http.StatusCreated, res.body)
+	}
+
+	genres, _ := field(res.envelope(t), "movie", "genres").([]any)
+	if fmt.Sprint(genres) != "[sci-fi drama]" {
+		t.Errorf("got genres %v, want [sci-fi drama]", genres)
+	}
+}
+
+func TestCreateMovieWithoutRuntime(t *testing.T) {
+	app := newTestApplication(t)
+	useTestDB(t, app)
+	ts := newTestServer(t, app.routes())
+
+	_, token := newTestUser(t, app, "movies:read", "movies:write")
+
+	res := ts.request(t, http.MethodPost, "/v1/movies", `{"title": "Severance", "year": 2022, "genres": ["drama"]}`, bearer(token))
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", res.status, http.StatusCreated, res.body)
+	}
+
+	movie, _ := field(res.envelope(t), "movie").(map[string]any)
+	if runtime, ok := movie["runtime"]; !ok || runtime != nil {
+		t.Errorf("got runtime %v (present %t), want null", runtime, ok)
+	}
+
+	// and it reads back the same way
+	res = ts.request(t, http.MethodGet, fmt.Sprintf("/v1/movies/%v", movie["id"]), "", bearer(token))
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", res.status, http.StatusOK)
+	}
+	if runtime := field(res.envelope(t), "movie", "runtime"); runtime != nil {
+		t.Errorf("got runtime %v after reading back, want null", runtime)
+	}
+}
+
+func TestCreateMovieMinYear(t *testing.T) {
+	body := `{"title": "Le Bourgeois gentilhomme", "year": 1700, "genres": ["comedy"]}`
+
+	tests := []struct {
+		name       string
+		minYear    int
+		wantStatus int
+	}{
+		{"default floor", 1888, http.StatusUnprocessableEntity},
+		{"lowered floor", 1700, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.movies.minYear = tt.minYear
+			useTestDB(t, app)
+			ts := newTestServer(t, app.routes())
+
+			_, token := newTestUser(t, app, "movies:read", "movies:write")
+
+			res := ts.request(t, http.MethodPost, "/v1/movies", body, bearer(token))
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+		})
+	}
+}
+
+func TestCreateMovieDuplicate(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.movies.unique = true
+	useTestDB(t, app)
+	ts := newTestServer(t, app.routes())
+
+	// what main does at startup with -enforce-unique-movies
+	err := app.models.Movies.EnsureUniqueTitleYear()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, token := newTestUser(t, app, "movies:read", "movies:write")
+
+	body := `{"title": "Moana", "year": 2016, "genres": ["animation"]}`
+
+	res := ts.request(t, http.MethodPost, "/v1/movies", body, bearer(token))
+	if res.status != http.StatusCreated {
+		t.Fatalf("first insert: got status %d, want %d: %s", res.status, http.StatusCreated, res.body)
+	}
+
+	// the failed insert aborts the test transaction, so it has to come last
+	res = ts.request(t, http.MethodPost, "/v1/movies", body, bearer(token))
+	if res.status != http.StatusUnprocessableEntity {
+		t.Fatalf("duplicate: got status %d, want %d: %s", res.status, http.StatusUnprocessableEntity, res.body)
+	}
+
+	if got := field(res.envelope(t), "error", "title"); got != "a movie with this title and year already exists" {
+		t.Errorf("got title error %v", got)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/souvikmndl/greenlight-api/internal/data"
+	"github.com/souvikmndl/greenlight-api/internal/validator"
+)
+
+// addUserPermissionsHandler grants permission codes to a user and returns
+// the full list of permissions they hold afterwards
+func (app *application) addUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePermissionCodes(v, input.Permissions); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(userID, input.Permissions...)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForuser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeUserPermissionsHandler revokes permission codes from a user and returns
+// the permissions they still hold afterwards
+func (app *application) removeUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePermissionCodes(v, input.Permissions); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
+		return
+	}
+
+	_, err = app.models.Users.GetByID(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Permissions.RemoveForUser(userID, input.Permissions...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForuser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
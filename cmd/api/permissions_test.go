@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestRevokePermission(t *testing.T) {
+	app := newTestApplication(t)
+	useTestDB(t, app)
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := newTestUser(t, app, "permissions:write")
+	user, token := newTestUser(t, app, "movies:read")
+
+	permissionsPath := fmt.Sprintf("/v1/users/%d/permissions", user.ID)
+	body := `{"permissions": ["movies:write"]}`
+
+	// deleting a movie that doesn't exist gets past requirePermission to a 404,
+	// or is turned away with a 403 when the user lacks movies:write
+	deleteMovie := func(t *testing.T) int {
+		t.Helper()
+		return ts.request(t, http.MethodDelete, "/v1/movies/999999999", "", bearer(token)).status
+	}
+
+	if status := deleteMovie(t); status != http.StatusForbidden {
+		t.Fatalf("before the grant: got status %d, want %d", status, http.StatusForbidden)
+	}
+
+	res := ts.request(t, http.MethodPost, permissionsPath, body, bearer(adminToken))
+	if res.status != http.StatusOK {
+		t.Fatalf("grant: got status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+
+	if status := deleteMovie(t); status != http.StatusNotFound {
+		t.Fatalf("after the grant: got status %d, want %d", status, http.StatusNotFound)
+	}
+
+	res = ts.request(t, http.MethodDelete, permissionsPath, body, bearer(adminToken))
+	if res.status != http.StatusOK {
+		t.Fatalf("revoke: got status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+
+	permissions, _ := field(res.envelope(t), "permissions").([]any)
+	if len(permissions) != 1 || permissions[0] != "movies:read" {
+		t.Errorf("got remaining permissions %v, want [movies:read]", permissions)
+	}
+
+	if status := deleteMovie(t); status != http.StatusForbidden {
+		t.Errorf("after the revoke: got status %d, want %d", status, http.StatusForbidden)
+	}
+}
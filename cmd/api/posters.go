@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/souvikmndl/greenlight-api/internal/data"
+	"github.com/souvikmndl/greenlight-api/internal/validator"
+)
+
+// posterExtensions maps the accepted poster content types to the extension they
+// are stored with, the extension is what GET uses to set the Content-Type
+var posterExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// multipartOverhead is allowed on top of the poster size for the multipart
+// boundaries and part headers
+const multipartOverhead = 64 << 10
+
+// uploadMoviePosterHandler stores a JPEG or PNG poster sent as the "poster" field
+// of a multipart/form-data body, replacing any poster the movie already had
+func (app *application) uploadMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	maxSize := app.config.posters.maxSize
+
+	file, header, err := app.readMultipart(w, r, "poster", maxSize+multipartOverhead)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotMultipart):
+			app.unsupportedMediaTypeResponse(w, r, err.Error())
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxSize {
+		message := fmt.Sprintf("must not be larger than %d bytes", maxSize)
+		app.failedValidationResponse(w, r, map[string]string{"poster": message},
+			validator.ValidationError{Field: "poster", Code: validator.CodeTooLarge, Message: message})
+		return
+	}
+
+	// the client supplied Content-Type of the part can't be trusted, so the
+	// type is sniffed from the first 512 bytes of the file itself
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ext, ok := posterExtensions[http.DetectContentType(head[:n])]
+	if !ok {
+		app.unsupportedMediaTypeResponse(w, r, "poster must be a JPEG or PNG image")
+		return
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.savePoster(id, ext, file)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	url := fmt.Sprintf("/v1/movies/%d/poster", id)
+
+	err = app.models.Movies.SetPoster(id, url)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", url)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"poster_url": url}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showMoviePosterHandler serves the poster stored for a movie
+func (app *application) showMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	for _, ext := range posterExtensions {
+		f, err := os.Open(app.posterPath(id, ext))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// ServeContent sets the Content-Type from the extension and
+		// handles range and If-Modified-Since requests
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+		return
+	}
+
+	app.notFoundResponse(w, r)
+}
+
+// posterPath is where the poster of movie id with the given extension is stored
+func (app *application) posterPath(id int64, ext string) string {
+	return filepath.Join(app.config.posters.dir, fmt.Sprintf("%d%s", id, ext))
+}
+
+// savePoster writes src to the poster path of movie id. It goes through a temp file
+// in the same dir so a failed upload never leaves a half written poster behind
+func (app *application) savePoster(id int64, ext string, src io.Reader) error {
+	err := os.MkdirAll(app.config.posters.dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(app.config.posters.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // a no-op once the rename below succeeded
+
+	_, err = io.Copy(tmp, src)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), app.posterPath(id, ext))
+	if err != nil {
+		return err
+	}
+
+	// a poster of the other type would otherwise shadow the new one
+	for _, other := range posterExtensions {
+		if other != ext {
+			err = os.Remove(app.posterPath(id, other))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
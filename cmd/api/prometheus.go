@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// promMetrics holds the Prometheus collectors served on /metrics. It has its own
+// registry so it is independent of expvar and of anything in the default registry
+type promMetrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+}
+
+// newPromMetrics registers the request collectors, plus the connection pool
+// stats of db and the usual Go runtime and process collectors
+func newPromMetrics(db *sql.DB) *promMetrics {
+	m := &promMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "greenlight_http_requests_total",
+			Help: "Total number of HTTP requests handled.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "greenlight_http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "greenlight_http_requests_in_flight",
+			Help: "Number of HTTP requests currently being handled.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.inFlight,
+		collectors.NewDBStatsCollector(db, "greenlight"),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// handler serves the registered metrics in the Prometheus exposition format
+func (m *promMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument records Prometheus request metrics, labelled by method, route
+// pattern (eg /v1/movies/:id, so ids don't blow up the label cardinality) and
+// status code. It is a no-op unless -enable-metrics is set
+func (app *application) instrument(router *httprouter.Router, next http.Handler) http.Handler {
+	if app.prom == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		app.prom.inFlight.Inc()
+		defer app.prom.inFlight.Dec()
+
+		mw := newMetricsResponseWriter(w)
+		next.ServeHTTP(mw, r)
+
+		route := routePattern(router, r)
+		status := strconv.Itoa(mw.statusCode)
+
+		app.prom.requests.WithLabelValues(r.Method, route, status).Inc()
+		app.prom.duration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// routePattern returns the httprouter pattern matching r, eg /v1/movies/:id for
+// /v1/movies/12. httprouter doesn't expose the pattern, so it is rebuilt from
+// the path by putting the param names back in place of their values
+func routePattern(router *httprouter.Router, r *http.Request) string {
+	handle, params, _ := router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return "unmatched"
+	}
+
+	// params come in path order, so each one is searched for after the previous
+	// match. Scanning from the end keeps a value like "v1" off the static segments
+	segments := strings.Split(r.URL.Path, "/")
+	end := len(segments)
+	for i := len(params) - 1; i >= 0; i-- {
+		for j := end - 1; j >= 0; j-- {
+			if segments[j] == params[i].Value {
+				segments[j] = ":" + params[i].Key
+				end = j
+				break
+			}
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
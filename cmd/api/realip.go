@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// defaultTrustedProxies are the loopback and private ranges load balancers usually sit in
+var defaultTrustedProxies = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("fc00::/7"),
+}
+
+// realIP returns the client's ip address. Unless -trust-proxy is set, or the request
+// didn't come from a trusted proxy, that is simply the peer address in r.RemoteAddr.
+//
+// Otherwise X-Forwarded-For is walked from the right, skipping our own trusted proxies,
+// and the first address not in -trusted-proxies is the client. Anything to the left of
+// it was written by the client itself and can't be believed. X-Real-IP is used when
+// there is no X-Forwarded-For
+func (app *application) realIP(r *http.Request) string {
+	remote := remoteIP(r)
+
+	if !app.config.proxy.trust || !app.trustedProxy(remote) {
+		return remote.String()
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+
+		client := remote
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				// a garbled hop means we can't tell who is further along, stop at the last good one
+				break
+			}
+
+			client = hop.Unmap()
+			if !app.trustedProxy(client) {
+				break
+			}
+		}
+
+		return client.String()
+	}
+
+	if xrip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return xrip.Unmap().String()
+	}
+
+	return remote.String()
+}
+
+// trustedProxy reports whether addr is in one of the -trusted-proxies ranges
+func (app *application) trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range app.config.proxy.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remoteIP parses the ip out of r.RemoteAddr, which net/http sets to "ip:port"
+func remoteIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
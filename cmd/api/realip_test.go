@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		remoteAddr string
+		xff        []string
+		xRealIP    string
+		want       string
+	}{
+		{"no proxy headers", true, "203.0.113.7:4000", nil, "", "203.0.113.7"},
+		{"proxy not trusted by config", false, "10.0.0.1:4000", []string{"198.51.100.2"}, "", "10.0.0.1"},
+		{"spoofed header from an untrusted peer", true, "203.0.113.7:4000", []string{"198.51.100.2"}, "", "203.0.113.7"},
+		{"spoofed x-real-ip from an untrusted peer", true, "203.0.113.7:4000", nil, "198.51.100.2", "203.0.113.7"},
+		{"one trusted proxy", true, "10.0.0.1:4000", []string{"198.51.100.2"}, "", "198.51.100.2"},
+		{"chain of trusted proxies", true, "10.0.0.1:4000", []string{"198.51.100.2, 192.168.1.5, 10.0.0.9"}, "", "198.51.100.2"},
+		// the client wrote the leftmost hop itself, only the one our proxy appended counts
+		{"client prepends a fake hop", true, "10.0.0.1:4000", []string{"1.2.3.4, 198.51.100.2"}, "", "198.51.100.2"},
+		{"client prepends a trusted looking hop", true, "10.0.0.1:4000", []string{"127.0.0.1, 198.51.100.2"}, "", "198.51.100.2"},
+		{"header split over several lines", true, "10.0.0.1:4000", []string{"1.2.3.4", "198.51.100.2"}, "", "198.51.100.2"},
+		{"garbled hop", true, "10.0.0.1:4000", []string{"198.51.100.2, not-an-ip"}, "", "10.0.0.1"},
+		{"every hop trusted", true, "10.0.0.1:4000", []string{"192.168.1.5"}, "", "192.168.1.5"},
+		{"x-real-ip from a trusted proxy", true, "10.0.0.1:4000", nil, "198.51.100.2", "198.51.100.2"},
+		{"ipv4 mapped ipv6", true, "[::ffff:10.0.0.1]:4000", []string{"::ffff:198.51.100.2"}, "", "198.51.100.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.proxy.trust = tt.trustProxy
+			app.config.proxy.trustedProxies = defaultTrustedProxies
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.xff {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.xRealIP != "" {
+				r.Header.Set("X-Real-IP", tt.xRealIP)
+			}
+
+			if got := app.realIP(r); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+)
+
+// liveConfig holds the settings which can be changed without a restart by sending
+// the process a SIGHUP. The middleware using them loads app.live on every request
+type liveConfig struct {
+	logLevel       slog.Level
+	limiterRPS     float64
+	limiterBurst   int
+	globalRPS      float64
+	globalBurst    int
+	trustedOrigins []string
+}
+
+func newLiveConfig(cfg config) *liveConfig {
+	var level slog.Level
+	// already checked by newLogger, an invalid level is left at info
+	level.UnmarshalText([]byte(cfg.log.level))
+
+	return &liveConfig{
+		logLevel:       level,
+		limiterRPS:     cfg.limiter.rps,
+		limiterBurst:   cfg.limiter.burst,
+		globalRPS:      cfg.limiter.globalRPS,
+		globalBurst:    cfg.limiter.globalBurst,
+		trustedOrigins: cfg.cors.trustedOrigins,
+	}
+}
+
+// handleReloads reloads the live config each time a SIGHUP comes in, until the
+// process exits
+func (app *application) handleReloads() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			err := app.reload()
+			if err != nil {
+				// the old settings stay in place
+				app.logger.Error("config reload failed", "error", err.Error())
+			}
+		}
+	}()
+}
+
+// reload reads the config again, from the same command line, config file and
+// environment as at startup, and swaps in the new live settings. Everything else
+// in the config only takes effect after a restart
+func (app *application) reload() error {
+	var cfg config
+
+	fs, _, _ := newFlagSet(&cfg, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	err := fs.Parse(os.Args[1:])
+	if err != nil {
+		return err
+	}
+
+	err = loadConfig(fs, app.configFile)
+	if err != nil {
+		return err
+	}
+
+	err = validateConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	var level slog.Level
+	err = level.UnmarshalText([]byte(cfg.log.level))
+	if err != nil {
+		return fmt.Errorf("invalid -log-level %q", cfg.log.level)
+	}
+
+	next := newLiveConfig(cfg)
+	prev := app.live.Swap(next)
+	app.logLevel.Set(next.logLevel)
+
+	changes := prev.diff(next)
+	if len(changes) == 0 {
+		app.logger.Info("config reloaded, nothing changed")
+		return nil
+	}
+
+	app.logger.Info("config reloaded", changes...)
+	return nil
+}
+
+// diff lists the settings that differ between c and next as slog key/value pairs
+// of the new values
+func (c *liveConfig) diff(next *liveConfig) []any {
+	var changes []any
+
+	if c.logLevel != next.logLevel {
+		changes = append(changes, "log_level", next.logLevel.String())
+	}
+	if c.limiterRPS != next.limiterRPS {
+		changes = append(changes, "limiter_rps", next.limiterRPS)
+	}
+	if c.limiterBurst != next.limiterBurst {
+		changes = append(changes, "limiter_burst", next.limiterBurst)
+	}
+	if c.globalRPS != next.globalRPS {
+		changes = append(changes, "limiter_global_rps", next.globalRPS)
+	}
+	if c.globalBurst != next.globalBurst {
+		changes = append(changes, "limiter_global_burst", next.globalBurst)
+	}
+	if !slices.Equal(c.trustedOrigins, next.trustedOrigins) {
+		changes = append(changes, "cors_trusted_origins", next.trustedOrigins)
+	}
+
+	return changes
+}
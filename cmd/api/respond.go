@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// xmlNameRX matches keys which can be used as XML element names as they are,
+// anything else (eg "movies[0].title") goes into a name attribute instead
+var xmlNameRX = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// respond writes data as XML for clients which explicitly ask for it in their
+// Accept header, and as JSON for everyone else
+func (app *application) respond(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	w.Header().Add("Vary", "Accept")
+
+	if prefersXML(r.Header.Get("Accept")) {
+		return app.writeXML(w, status, data, headers)
+	}
+
+	return app.writeJSON(w, status, data, headers)
+}
+
+// prefersXML reports whether an Accept header names application/xml or text/xml
+// with a higher weight than application/json. Wildcards don't count, a client
+// has to ask for XML to get it
+func prefersXML(accept string) bool {
+	xmlQ, jsonQ := -1.0, -1.0
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		switch mediaType {
+		case "application/xml", "text/xml":
+			xmlQ = max(xmlQ, q)
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+
+	return xmlQ > 0 && xmlQ > jsonQ
+}
+
+// writeXML is the XML counterpart of writeJSON. data goes through its JSON form
+// first, so struct tags and custom MarshalJSON methods (eg Runtime) apply the
+// same way in both formats
+func (app *application) writeXML(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	// keep numbers exactly as encoded, instead of going through float64
+	dec.UseNumber()
+
+	var value any
+	err = dec.Decode(&value)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "\t")
+
+	err = encodeXML(enc, xml.StartElement{Name: xml.Name{Local: "response"}}, value)
+	if err != nil {
+		return err
+	}
+
+	err = enc.Flush()
+	if err != nil {
+		return err
+	}
+
+	buf.WriteByte('\n')
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+
+	return nil
+}
+
+// encodeXML writes a decoded JSON value as the element start. Objects become one
+// child element per key in sorted order, arrays a child <item> per element
+func encodeXML(enc *xml.Encoder, start xml.StartElement, value any) error {
+	err := enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			err = encodeXML(enc, xmlElement(key), value[key])
+			if err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range value {
+			err = encodeXML(enc, xml.StartElement{Name: xml.Name{Local: "item"}}, item)
+			if err != nil {
+				return err
+			}
+		}
+	case nil:
+		// null is an empty element
+	default:
+		err = enc.EncodeToken(xml.CharData(fmt.Sprint(value)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// xmlElement returns the element for an object key, keys which aren't valid
+// XML names are kept in a name attribute of a generic <field> element
+func xmlElement(key string) xml.StartElement {
+	if xmlNameRX.MatchString(key) && !strings.HasPrefix(strings.ToLower(key), "xml") {
+		return xml.StartElement{Name: xml.Name{Local: key}}
+	}
+
+	return xml.StartElement{
+		Name: xml.Name{Local: "field"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: key}},
+	}
+}
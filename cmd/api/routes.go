@@ -15,28 +15,80 @@ func (app *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
+	// the token and password endpoints are brute-force targets, so they get a much
+	// tighter limit. Each route wrapped gets its own buckets
+	authLimit := func(next http.HandlerFunc) http.HandlerFunc {
+		return app.rateLimitFor(app.config.limiter.authRPS, app.config.limiter.authBurst, next)
+	}
+
+	// /v1/healthcheck is kept as an alias of the readiness check
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthCheckHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck/live", app.liveHealthCheckHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck/ready", app.healthCheckHandler)
 
 	// movie routes
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.staticOrID(map[string]http.HandlerFunc{
+		"stats":  app.requirePermission("movies:read", app.movieStatsHandler),
+		"export": app.requirePermission("movies:read", app.exportMoviesHandler),
+	}, app.requirePermission("movies:read", app.showMovieHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/similar", app.requirePermission("movies:read", app.similarMoviesHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
+	// batch shares the POST /:id route so /v1/movies/:id/poster can be registered
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.staticOrID(map[string]http.HandlerFunc{
+		"batch": app.requirePermission("movies:write", app.createMoviesBatchHandler),
+	}, app.methodNotAllowedResponse))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/poster", app.requirePermission("movies:read", app.showMoviePosterHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.uploadMoviePosterHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
 
 	// users routes
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", authLimit(app.updateUserPasswordHandler))
+
+	// admin routes
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id", app.requirePermission("users:read", app.showUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/permissions", app.requirePermission("permissions:write", app.addUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/permissions", app.requirePermission("permissions:write", app.removeUserPermissionsHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", authLimit(app.createAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokensHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/current", app.requireAuthenticatedUser(app.deleteCurrentAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", authLimit(app.createActivationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", authLimit(app.createPasswordResetTokenHandler))
 
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	if app.prom != nil {
+		router.Handler(http.MethodGet, "/metrics", app.prom.handler())
+	}
 
 	// this recoverPanic middleware will only handle panics in main thread
 	// if we spin up our own threads and there is a panic in them, that wont
 	// be handled and our app will crash. We will need to handle panics in
 	// each thread that we spin up.
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return app.requestID(app.trace(router, app.logRequest(app.metrics(app.instrument(router, app.compress(app.recoverPanic(app.enableCORS(app.maintenance(app.timeout(app.rateLimit(app.authenticate(router))))))))))))
 	// rateLimit is added after recoverPanic so that panic in the limiter is handled as well
 	// the RL mw will be before all others to reject requests without procesing in case of limits
+	// requestID is outermost so every log line, including recovered panics, carries the id
+	// logRequest sits just inside it so it sees the final status set by all the other mw
+	// trace and instrument use the router to label each request with its route pattern
+	// maintenance and timeout sit inside enableCORS so their 503s keep the CORS headers
+}
+
+// staticOrID routes /:id requests whose id is one of the static names to that
+// handler, and everything else to byID. httprouter won't register a static
+// segment like /v1/movies/stats next to /v1/movies/:id, so they share the route
+func (app *application) staticOrID(static map[string]http.HandlerFunc, byID http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if next, ok := static[params.ByName("id")]; ok {
+			next(w, r)
+			return
+		}
+
+		byID(w, r)
+	}
 }
@@ -14,16 +14,23 @@ import (
 
 func (app *application) serve() error {
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", app.config.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		Addr:        fmt.Sprintf(":%d", app.config.port),
+		Handler:     app.routes(),
+		IdleTimeout: app.config.http.idleTimeout,
+		ReadTimeout: app.config.http.readTimeout,
+		// bounds slow clients trickling in headers (slowloris), even when
+		// ReadTimeout is raised to allow for large request bodies
+		ReadHeaderTimeout: app.config.http.readHeaderTimeout,
+		WriteTimeout:      app.config.http.writeTimeout,
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 
 	shutdownError := make(chan error)
 
+	// stops the periodic tasks when the server shuts down
+	tasksCtx, stopTasks := context.WithCancel(context.Background())
+	defer stopTasks()
+
 	// start a background go routine, it will rn for the lifetime of our application
 	// and catch signals we specify
 	go func() {
@@ -42,14 +49,16 @@ func (app *application) serve() error {
 
 		app.logger.Info("caught signal", "signal", s.String())
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		app.wg.Wait()
-		// graceful shutdown
-		shutdownError <- srv.Shutdown(ctx)
+		shutdownError <- app.shutdown(srv, stopTasks)
 	}()
 
+	// SIGHUP reloads part of the config instead of stopping the server
+	app.handleReloads()
+	// SIGUSR1 toggles maintenance mode
+	app.handleMaintenanceToggle()
+
+	app.purgeExpiredTokens(tasksCtx, app.config.tokenCleanup)
+
 	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
 
 	// calling shutdown() on our server will cause ListenAndServe() to immediately return
@@ -72,9 +81,84 @@ func (app *application) serve() error {
 	return nil
 }
 
+// shutdown stops srv gracefully, giving in-flight requests up to the shutdown timeout,
+// then stops the periodic tasks and waits for the background work to finish
+func (app *application) shutdown(srv *http.Server, stopTasks func()) error {
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+	defer cancel()
+
+	// graceful shutdown, stop accepting requests first so no new
+	// background tasks get started while we wait for the existing ones
+	err := srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		app.logger.Warn("shutdown timeout exceeded, in-flight requests were cut off",
+			"timeout", app.config.shutdownTimeout.String())
+	}
+
+	app.logger.Info("completing background tasks", "addr", srv.Addr)
+
+	stopTasks()
+
+	// send any emails still queued, workers stop once the queue is drained
+	err = errors.Join(err, app.mailQueue.Shutdown(ctx))
+
+	// block until all background routines have called wg.Done(). This happens even
+	// if ctx has expired so we don't exit with goroutines half way through their work
+	app.wg.Wait()
+
+	return err
+}
+
 /*
 It’s important to be aware that the Shutdown() method does not wait for any background
 tasks to complete, nor does it close hijacked long-lived connections like WebSockets.
 Instead, you will need to implement your own logic to coordinate a graceful shutdown of
-these things.
+these things. For our background tasks this is done with app.wg in the shutdown routine.
 */
+
+// purgeExpiredTokens deletes expired tokens every interval until ctx is done. The
+// loop is counted in app.wg and runs each purge itself, so shutdown waits for a purge
+// that is under way and nothing calls app.wg.Add once shutdown has started waiting
+func (app *application) purgeExpiredTokens(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// the tick and ctx.Done can be ready together, select picks either
+				if ctx.Err() != nil {
+					return
+				}
+				app.purgeTokens()
+			}
+		}
+	}()
+}
+
+// purgeTokens runs a single purge, a panic is recovered so it can't end the loop
+func (app *application) purgeTokens() {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.Error("purging expired tokens panicked", "error", fmt.Sprintf("%v", err))
+		}
+	}()
+
+	deleted, err := app.models.Tokens.DeleteExpired()
+	if err != nil {
+		app.logger.Error("purging expired tokens failed", "error", err.Error())
+		return
+	}
+
+	app.logger.Info("purged expired tokens", "deleted", deleted)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShutdownTimeout(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.shutdownTimeout = 50 * time.Millisecond
+
+	var logs bytes.Buffer
+	app.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	inFlight := make(chan struct{})
+	release := make(chan struct{})
+
+	// the handler outlasts the shutdown timeout
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(inFlight)
+		<-release
+	})}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+
+	requestDone := make(chan struct{})
+	go func() {
+		defer close(requestDone)
+
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+
+	t.Cleanup(func() {
+		close(release)
+		srv.Close()
+		<-requestDone
+	})
+
+	<-inFlight
+
+	// background work also outlasts the timeout, shutdown must still wait for it
+	var finished atomic.Bool
+	app.background(func() {
+		time.Sleep(4 * app.config.shutdownTimeout)
+		finished.Store(true)
+	})
+
+	var tasksStopped bool
+	err = app.shutdown(srv, func() { tasksStopped = true })
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want context.DeadlineExceeded", err)
+	}
+	if !finished.Load() {
+		t.Error("shutdown returned before the background task finished")
+	}
+	if !tasksStopped {
+		t.Error("the periodic tasks were not stopped")
+	}
+	if !strings.Contains(logs.String(), "shutdown timeout exceeded") {
+		t.Errorf("no timeout warning was logged, got logs:\n%s", logs.String())
+	}
+}
+
+func TestPurgeExpiredTokensStops(t *testing.T) {
+	app := newTestApplication(t)
+
+	var logs bytes.Buffer
+	app.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	// without a test database the models have no DB, so every purge panics
+	ctx, cancel := context.WithCancel(context.Background())
+	app.purgeExpiredTokens(ctx, time.Millisecond)
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the purge loop was still running after ctx was cancelled")
+	}
+
+	// wg.Wait returning means the loop is done writing, so logs is safe to read
+	if got := strings.Count(logs.String(), "purging expired tokens panicked"); got < 2 {
+		t.Errorf("got %d recovered purges, want the loop to carry on past the first, logs:\n%s", got, logs.String())
+	}
+}
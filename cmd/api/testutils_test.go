@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/souvikmndl/greenlight-api/internal/data"
+	"github.com/souvikmndl/greenlight-api/internal/mailer"
+	"github.com/souvikmndl/greenlight-api/internal/testhelpers"
+)
+
+// newTestApplication returns an application with the default config, a MockMailer
+// and logs thrown away. It has no database, handlers reaching for one panic unless
+// useTestDB is called. The rate limiter is off so tests can make as many requests
+// as they like
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	var cfg config
+
+	fs, _, _ := newFlagSet(&cfg, flag.ContinueOnError)
+	err := fs.Parse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.limiter.enabled = false
+	cfg.posters.dir = t.TempDir()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mock := &mailer.MockMailer{}
+
+	app := &application{
+		config:    cfg,
+		logger:    logger,
+		mailQueue: mailer.NewQueue(mock, logger, 1, 10),
+		logLevel:  new(slog.LevelVar),
+	}
+	app.live.Store(newLiveConfig(cfg))
+
+	t.Cleanup(func() {
+		app.mailQueue.Shutdown(context.Background())
+	})
+
+	return app
+}
+
+// useTestDB points app at the test database, its models run on a transaction
+// which is rolled back when t finishes, WithTx nests in it with a savepoint. t is
+// skipped when there is no test database
+func useTestDB(t *testing.T, app *application) {
+	t.Helper()
+
+	app.db = testhelpers.DB(t)
+
+	app.models = data.NewModelsTx(testhelpers.Tx(t), app.config.db.queryTimeout)
+}
+
+// userSeq numbers the test users, so a test can make more than one of them
+var userSeq atomic.Int64
+
+// newTestUser inserts an activated user holding permissions and returns them with
+// a valid authentication token. app must be using the test database
+func newTestUser(t *testing.T, app *application, permissions ...string) (*data.User, string) {
+	t.Helper()
+
+	user := &data.User{
+		Name:      "Test User",
+		Email:     fmt.Sprintf("%s.%d@example.com", strings.ReplaceAll(strings.ToLower(t.Name()), "/", "."), userSeq.Add(1)),
+		Activated: true,
+	}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(permissions) > 0 {
+		err = app.models.Permissions.AddForUser(user.ID, permissions...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, token.Plaintext
+}
+
+// testServer runs a handler, usually app.routes(), on a local httptest.Server
+type testServer struct {
+	*httptest.Server
+}
+
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+
+	return &testServer{ts}
+}
+
+// testResponse is a response to a testServer request, with its body read
+type testResponse struct {
+	status  int
+	headers http.Header
+	body    []byte
+}
+
+// request sends a request to urlPath with the optional JSON body and headers
+func (ts *testServer) request(t *testing.T, method, urlPath, body string, headers http.Header) testResponse {
+	t.Helper()
+
+	var reqBody io.Reader
+	if body != "" {
+		reqBody = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+urlPath, reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testResponse{status: res.StatusCode, headers: res.Header, body: resBody}
+}
+
+// bearer returns the headers authenticating a request with token
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// envelope decodes the JSON body into a map, numbers are kept as json.Number
+func (res testResponse) envelope(t *testing.T) map[string]any {
+	t.Helper()
+
+	dec := json.NewDecoder(bytes.NewReader(res.body))
+	dec.UseNumber()
+
+	var env map[string]any
+	err := dec.Decode(&env)
+	if err != nil {
+		t.Fatalf("decoding response body %q: %v", res.body, err)
+	}
+
+	return env
+}
+
+// field walks the keys of path down a decoded envelope, eg field(env, "movie", "title"),
+// returning nil when any of them is missing
+func field(env map[string]any, path ...string) any {
+	var value any = env
+
+	for _, key := range path {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+
+	return value
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/souvikmndl/greenlight-api/internal/data"
+	"github.com/souvikmndl/greenlight-api/internal/mailer"
 	"github.com/souvikmndl/greenlight-api/internal/validator"
 )
 
@@ -23,11 +24,11 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 
 	v := validator.New()
 
-	data.ValidateEmail(v, input.Email)
-	data.ValidatePasswordPlainText(v, input.Password)
+	app.validateEmail(v, input.Email)
+	data.ValidatePasswordPlaintext(v, input.Password)
 
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
 		return
 	}
 
@@ -64,3 +65,174 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// deleteAuthenticationTokensHandler logs the user out everywhere by revoking
+// all of their authentication tokens
+func (app *application) deleteAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "tokens revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCurrentAuthenticationTokenHandler only revokes the token sent with this
+// request, so sessions on the user's other devices stay logged in
+func (app *application) deleteCurrentAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// authenticate has already checked the header by the time we get here
+	token, err := app.readBearerToken(r)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteForPlaintext(data.ScopeAuthentication, token)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "token revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasswordResetTokenHandler mails a password reset token to an activated user.
+// The response is the same whether or not the email matches an account, so it can't
+// be used to find out which email addresses are registered
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if app.validateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
+		return
+	}
+
+	env := envelope{"message": "if an activated account with that email exists, an email will be sent to it containing password reset instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.mailQueue.Enqueue(mailer.Job{
+			Recipient:    user.Email,
+			TemplateFile: "token_password_reset.tmpl",
+			Data:         map[string]any{"passwordResetToken": token.Plaintext},
+		})
+		if err != nil {
+			// still a 202, a 500 here would only ever come back for registered emails
+			app.logError(r, err)
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createActivationTokenHandler sends a fresh activation token to a user who hasn't
+// activated their account yet, replacing any tokens sent to them before
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if app.validateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
+		return
+	}
+
+	// unknown and already activated accounts get the same message
+	const noAccountMessage = "no account awaiting activation was found for this email address"
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddErrorCode("email", validator.CodeNotFound, noAccountMessage)
+			app.failedValidationResponse(w, r, v.Errors, v.Details()...)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		v.AddErrorCode("email", validator.CodeNotFound, noAccountMessage)
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.mailQueue.Enqueue(mailer.Job{
+		Recipient:    user.Email,
+		TemplateFile: "token_activation.tmpl",
+		Data:         map[string]any{"activationToken": token.Plaintext},
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/souvikmndl/greenlight-api/internal/data"
+)
+
+func TestRevokeAuthenticationTokens(t *testing.T) {
+	tests := []struct {
+		name        string
+		urlPath     string
+		wantMessage string
+		// whether the user's other token still works afterwards
+		wantOtherValid bool
+	}{
+		{"all tokens", "/v1/tokens/authentication", "tokens revoked", false},
+		{"current token", "/v1/tokens/authentication/current", "token revoked", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			useTestDB(t, app)
+			ts := newTestServer(t, app.routes())
+
+			user, token := newTestUser(t, app, "movies:read")
+
+			// a session on a second device
+			other, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopeAuthentication)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := ts.request(t, http.MethodDelete, tt.urlPath, "", bearer(token))
+			if res.status != http.StatusOK {
+				t.Fatalf("got status %d, want %d, body %s", res.status, http.StatusOK, res.body)
+			}
+			if got := field(res.envelope(t), "message"); got != tt.wantMessage {
+				t.Errorf("got message %v, want %q", got, tt.wantMessage)
+			}
+
+			// the revoked token is rejected by the authenticate middleware
+			res = ts.request(t, http.MethodGet, "/v1/movies", "", bearer(token))
+			if res.status != http.StatusUnauthorized {
+				t.Errorf("revoked token: got status %d, want %d", res.status, http.StatusUnauthorized)
+			}
+			if got := field(res.envelope(t), "error"); got != "invalid or missing authentication token" {
+				t.Errorf("revoked token: got error %v", got)
+			}
+
+			wantStatus := http.StatusUnauthorized
+			if tt.wantOtherValid {
+				wantStatus = http.StatusOK
+			}
+
+			res = ts.request(t, http.MethodGet, "/v1/movies", "", bearer(other.Plaintext))
+			if res.status != wantStatus {
+				t.Errorf("other token: got status %d, want %d", res.status, wantStatus)
+			}
+		})
+	}
+}
+
+func TestPasswordResetSameResponse(t *testing.T) {
+	app := newTestApplication(t)
+	useTestDB(t, app)
+	ts := newTestServer(t, app.routes())
+
+	user, _ := newTestUser(t, app)
+
+	// a closed queue fails every Enqueue, which must not give away that the email is registered
+	err := app.mailQueue.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bodies []string
+
+	for _, email := range []string{user.Email, "nobody@example.com"} {
+		res := ts.request(t, http.MethodPost, "/v1/tokens/password-reset", fmt.Sprintf(`{"email": %q}`, email), nil)
+		if res.status != http.StatusAccepted {
+			t.Errorf("%s: got status %d, want %d, body %s", email, res.status, http.StatusAccepted, res.body)
+		}
+		bodies = append(bodies, string(res.body))
+	}
+
+	if bodies[0] != bodies[1] {
+		t.Errorf("registered email got %s, unknown email got %s", bodies[0], bodies[1])
+	}
+}
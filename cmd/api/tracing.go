@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// setupTracing exports spans over OTLP/HTTP to endpoint, eg http://localhost:4318.
+// With no endpoint nothing is set up and the global tracer provider stays a no-op.
+// The returned func flushes any buffered spans and must be called before exiting
+func setupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "greenlight"),
+			attribute.String("service.version", version),
+		)),
+	)
+
+	otel.SetTracerProvider(provider)
+	// continue traces started upstream, from their traceparent and baggage headers
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// trace wraps every request in a span named after its route pattern, eg
+// "GET /v1/movies/:id". Without -otel-endpoint it isn't added to the chain at all
+func (app *application) trace(router *httprouter.Router, next http.Handler) http.Handler {
+	if app.config.otel.endpoint == "" {
+		return next
+	}
+
+	return otelhttp.NewHandler(next, "http.request",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + routePattern(router, r)
+		}),
+	)
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/souvikmndl/greenlight-api/internal/data"
+	"github.com/souvikmndl/greenlight-api/internal/mailer"
 	"github.com/souvikmndl/greenlight-api/internal/validator"
 )
 
@@ -34,61 +35,125 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	v := validator.New()
+	// multi-error mode, each field lists every rule it failed
+	v := validator.NewMulti()
 
-	if data.ValidateUser(v, user); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	if data.ValidateUser(v, user, app.validateEmail); !v.Valid() {
+		app.failedMultiValidationResponse(w, r, v.Errors, v.Details()...)
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	// the user, their permissions and the activation token are written in one tx,
+	// otherwise a failed token insert leaves a user behind who can never activate
+	var token *data.Token
+	err = app.models.WithTx(r.Context(), func(models data.Models) error {
+		err := models.Users.Insert(user)
+		if err != nil {
+			return err
+		}
+
+		if len(app.config.users.defaultPermissions) > 0 {
+			err = models.Permissions.AddForUser(user.ID, app.config.users.defaultPermissions...)
+			if err != nil {
+				return err
+			}
+		}
+
+		token, err = models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		return err
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
-			v.AddError("email", "a user with this email address already exists")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddErrorCode("email", validator.CodeAlreadyExists, "a user with this email address already exists")
+			app.failedMultiValidationResponse(w, r, v.Errors, v.Details()...)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	// sending welcome email, the mail queue workers send it in the background so
+	// the client doesn't have to wait on the SMTP server
+	err = app.mailQueue.Enqueue(mailer.Job{
+		Recipient:    user.Email,
+		TemplateFile: "user_welcome.tmpl",
+		Data: map[string]any{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+		},
+	})
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+		// the user has been created at this point so we don't fail the request
+		app.logError(r, err)
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	// 202 Accepted as the activation email is still being sent in the background
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	// sending welcome email
-	// panic in a background routine must be recovered else it will terminate the whole app
-	app.background(func() {
-		data := map[string]any{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors, v.Details()...)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddErrorCode("token", validator.CodeNotFound, "invalid or expired activation token")
+			app.failedValidationResponse(w, r, v.Errors, v.Details()...)
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
+		return
+	}
 
-		err := app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			app.logger.Error(err.Error())
-			return
+	user.Activated = true
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
-		app.logger.Info("email sent", "success", true)
-	})
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+// updateUserPasswordHandler sets a new password for the user a password reset token belongs to
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
+		Password       string `json:"password"`
 		TokenPlaintext string `json:"token"`
 	}
 
@@ -98,26 +163,34 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	v := validator.New()
+	// multi-error mode, each field lists every rule it failed
+	v := validator.NewMulti()
 
-	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedMultiValidationResponse(w, r, v.Errors, v.Details()...)
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("token", "invalid or expired token")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddErrorCode("token", validator.CodeNotFound, "invalid or expired password reset token")
+			app.failedMultiValidationResponse(w, r, v.Errors, v.Details()...)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	user.Activated = true
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
 	err = app.models.Users.Update(user)
 	if err != nil {
@@ -130,12 +203,38 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUserHandler returns a single user's profile, the password hash is
+// never part of the response as User.Password is tagged json:"-"
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
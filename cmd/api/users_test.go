@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/souvikmndl/greenlight-api/internal/data"
+)
+
+// failingExecDB fails every ExecContext, the rest goes through to the wrapped DBTX
+type failingExecDB struct {
+	data.DBTX
+}
+
+func (db failingExecDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return nil, errors.New("forced exec failure")
+}
+
+func TestRegisterUserRollsBack(t *testing.T) {
+	app := newTestApplication(t)
+	useTestDB(t, app)
+	ts := newTestServer(t, app.routes())
+
+	// only the tokens model fails, so the user and their default permissions are written
+	// before the token insert. WithTx keeps the wrapper, the test models already run on
+	// the test transaction
+	app.models.Tokens.DB = failingExecDB{app.models.Tokens.DB}
+
+	email := fmt.Sprintf("rollback.%d@example.com", userSeq.Add(1))
+	body := fmt.Sprintf(`{"name": "Alice", "email": %q, "password": "pa55word1234"}`, email)
+
+	res := ts.request(t, http.MethodPost, "/v1/users", body, nil)
+	if res.status != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want %d, body %s", res.status, http.StatusInternalServerError, res.body)
+	}
+
+	_, err := app.models.Users.GetByEmail(email)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("got error %v looking up the user, want ErrRecordNotFound after the rollback", err)
+	}
+}
@@ -0,0 +1,192 @@
+// seed fills a development database with sample movies and an admin user, it
+// goes through the same data models as the api so the rows look like real ones
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/souvikmndl/greenlight-api/internal/data"
+	"github.com/souvikmndl/greenlight-api/internal/validator"
+)
+
+var (
+	adjectives = []string{"Silent", "Crimson", "Last", "Hidden", "Broken", "Golden", "Midnight", "Lost", "Electric", "Frozen"}
+	nouns      = []string{"River", "Empire", "Horizon", "Garden", "Signal", "Harbor", "Kingdom", "Mirror", "Voyage", "Code"}
+	genres     = []string{"action", "adventure", "animation", "comedy", "crime", "drama", "fantasy", "horror", "romance", "sci-fi", "thriller", "western"}
+)
+
+type config struct {
+	dsn           string
+	movies        int
+	force         bool
+	seed          uint64
+	adminName     string
+	adminEmail    string
+	adminPassword string
+}
+
+func main() {
+	var cfg config
+
+	flag.StringVar(&cfg.dsn, "db-dsn", os.Getenv("GREENLIGHT_DB_DSN"), "PostgreSQL DSN")
+	flag.IntVar(&cfg.movies, "movies", 100, "Number of fake movies to insert")
+	flag.BoolVar(&cfg.force, "force", false, "Insert movies even when the movies table isn't empty")
+	flag.Uint64Var(&cfg.seed, "seed", 1, "Random seed, the same seed gives the same movies")
+	flag.StringVar(&cfg.adminName, "admin-name", "Admin", "Name of the admin user")
+	flag.StringVar(&cfg.adminEmail, "admin-email", "admin@example.com", "Email of the admin user")
+	flag.StringVar(&cfg.adminPassword, "admin-password", "pa55word", "Password of the admin user")
+
+	flag.Parse()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	err := run(cfg, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(cfg config, logger *slog.Logger) error {
+	if cfg.dsn == "" {
+		return errors.New("no dsn: set -db-dsn or GREENLIGHT_DB_DSN")
+	}
+
+	db, err := sql.Open("postgres", cfg.dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	models := data.NewModels(db, 3*time.Second)
+
+	err = seedAdmin(models, cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	return seedMovies(models, cfg, logger)
+}
+
+// seedAdmin creates an activated admin user holding every permission. Running it
+// again finds the existing user and only tops up its permissions
+func seedAdmin(models data.Models, cfg config, logger *slog.Logger) error {
+	user := &data.User{
+		Name:      cfg.adminName,
+		Email:     cfg.adminEmail,
+		Activated: true,
+	}
+
+	err := user.Password.Set(cfg.adminPassword)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+	if data.ValidateUser(v, user, data.ValidateEmail); !v.Valid() {
+		return fmt.Errorf("invalid admin user: %v", v.Errors)
+	}
+
+	err = models.Users.Insert(user)
+	switch {
+	case errors.Is(err, data.ErrDuplicateEmail):
+		user, err = models.Users.GetByEmail(cfg.adminEmail)
+		if err != nil {
+			return err
+		}
+		logger.Info("admin user already exists", "email", user.Email)
+	case err != nil:
+		return err
+	default:
+		logger.Info("admin user created", "email", user.Email)
+	}
+
+	// AddForUser skips permissions the user already has
+	return models.Permissions.AddForUser(user.ID, data.PermissionCodes...)
+}
+
+// seedMovies inserts cfg.movies random movies, unless there already are movies
+// in the table, so running the seed twice doesn't double them up
+func seedMovies(models data.Models, cfg config, logger *slog.Logger) error {
+	_, metadata, err := models.Movies.GetAll(context.Background(), "", []string{}, time.Time{}, time.Time{}, data.Filters{
+		Page:         1,
+		PageSize:     1,
+		Sort:         "id",
+		SortSafelist: []string{"id"},
+	})
+	if err != nil {
+		return err
+	}
+
+	if metadata.TotalRecords > 0 && !cfg.force {
+		logger.Info("movies table not empty, skipping movies (use -force to add anyway)", "movies", metadata.TotalRecords)
+		return nil
+	}
+
+	rng := rand.New(rand.NewPCG(cfg.seed, cfg.seed))
+	thisYear := time.Now().Year()
+
+	// all or nothing, a failure halfway doesn't leave a partial seed behind for the
+	// next run to skip over
+	err = models.WithTx(context.Background(), func(models data.Models) error {
+		for i := range cfg.movies {
+			runtime := data.Runtime(70 + rng.IntN(120))
+
+			// the number keeps (title, year) unique, there are only 100 random titles.
+			// It carries on from the existing movies so a -force run doesn't collide either
+			movie := &data.Movie{
+				Title: fmt.Sprintf("The %s %s %d", adjectives[rng.IntN(len(adjectives))], nouns[rng.IntN(len(nouns))],
+					metadata.TotalRecords+i+1),
+				Year:    int32(1950 + rng.IntN(thisYear-1950+1)),
+				Runtime: &runtime,
+				Genres:  data.NormalizeGenres(randomGenres(rng)),
+			}
+
+			v := validator.New()
+			if data.ValidateMovie(v, movie, data.MinMovieYear); !v.Valid() {
+				return fmt.Errorf("invalid movie %d: %v", i, v.Errors)
+			}
+
+			err := models.Movies.Insert(context.Background(), movie)
+			if err != nil {
+				return fmt.Errorf("movie %d: %w", i, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("movies created", "movies", cfg.movies)
+	return nil
+}
+
+// randomGenres picks between 1 and 3 distinct genres
+func randomGenres(rng *rand.Rand) []string {
+	picked := make([]string, 0, 3)
+
+	for _, i := range rng.Perm(len(genres))[:1+rng.IntN(3)] {
+		picked = append(picked, genres[i])
+	}
+
+	return picked
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"math"
 	"strings"
 
 	"github.com/souvikmndl/greenlight-api/internal/validator"
@@ -16,12 +17,12 @@ type Filters struct {
 
 // ValidateFilters checks whether filter values are set correctly
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
-	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.CheckCode(f.Page > 0, "page", validator.CodeTooSmall, "must be greater than zero")
+	v.CheckCode(f.Page <= 10_000_000, "page", validator.CodeTooLarge, "must be a maximum of 10 million")
+	v.CheckCode(f.PageSize > 0, "page_size", validator.CodeTooSmall, "must be greater than zero")
+	v.CheckCode(f.PageSize <= 100, "page_size", validator.CodeTooLarge, "must be a maximum of 100")
 
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.CheckCode(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", validator.CodeNotPermitted, "invalid sort value")
 }
 
 /*
@@ -53,8 +54,18 @@ func (f Filters) limit() int {
 	return f.PageSize
 }
 
-// offset returns the number of rows to skip for pagination
+// offset returns the number of rows to skip for pagination. ValidateFilters keeps
+// this under 1 billion, but on 32-bit platforms unvalidated values could overflow int,
+// so we clamp to math.MaxInt which simply returns an empty page
 func (f Filters) offset() int {
+	if f.Page < 1 || f.PageSize < 1 {
+		return 0
+	}
+
+	if f.Page-1 > math.MaxInt/f.PageSize {
+		return math.MaxInt
+	}
+
 	return (f.Page - 1) * f.PageSize
 }
 
@@ -0,0 +1,37 @@
+package data
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFiltersLimitOffset(t *testing.T) {
+	tests := []struct {
+		name       string
+		page       int
+		pageSize   int
+		wantLimit  int
+		wantOffset int
+	}{
+		{"first page", 1, 20, 20, 0},
+		{"second page", 2, 20, 20, 20},
+		{"last allowed page", 10_000_000, 100, 100, 999_999_900},
+		{"page zero", 0, 20, 20, 0},
+		{"page size zero", 3, 0, 0, 0},
+		{"overflow", math.MaxInt, 100, 100, math.MaxInt},
+		{"just under overflow", math.MaxInt/100 + 1, 100, 100, (math.MaxInt / 100) * 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filters{Page: tt.page, PageSize: tt.pageSize}
+
+			if got := f.limit(); got != tt.wantLimit {
+				t.Errorf("limit() = %d, want %d", got, tt.wantLimit)
+			}
+			if got := f.offset(); got != tt.wantOffset {
+				t.Errorf("offset() = %d, want %d", got, tt.wantOffset)
+			}
+		})
+	}
+}
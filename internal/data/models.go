@@ -1,8 +1,11 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
 )
 
 var (
@@ -12,20 +15,109 @@ var (
 	ErrEditConflict = errors.New("edit conflict")
 )
 
+// DBTX is the part of *sql.DB that models run their queries through, *sql.Tx
+// satisfies it too so the same model can run inside a transaction
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
 // Models wraps all individual models
 type Models struct {
 	Movies      MovieModel
 	Permissions PermissionModel
 	Users       UserModel
 	Tokens      TokenModel
+
+	db *sql.DB
+	// tx is set by NewModelsTx, WithTx then nests in it with a savepoint
+	tx *sql.Tx
+}
+
+// NewModels creates a new instances of models inside Models, every query they
+// run is cancelled once it takes longer than queryTimeout
+func NewModels(db *sql.DB, queryTimeout time.Duration) Models {
+	return Models{
+		Movies:      MovieModel{DB: db, QueryTimeout: queryTimeout},
+		Permissions: PermissionModel{DB: db, QueryTimeout: queryTimeout},
+		Tokens:      TokenModel{DB: db, QueryTimeout: queryTimeout},
+		Users:       UserModel{DB: db, QueryTimeout: queryTimeout},
+
+		db: db,
+	}
 }
 
-// NewModels creates a new instances of models inside Models
-func NewModels(db *sql.DB) Models {
+// NewModelsTx creates models running every query on tx, for tests which roll tx back
+// once they finish. A transaction can't be begun inside tx, so WithTx uses a savepoint
+func NewModelsTx(tx *sql.Tx, queryTimeout time.Duration) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		Movies:      MovieModel{DB: tx, QueryTimeout: queryTimeout},
+		Permissions: PermissionModel{DB: tx, QueryTimeout: queryTimeout},
+		Tokens:      TokenModel{DB: tx, QueryTimeout: queryTimeout},
+		Users:       UserModel{DB: tx, QueryTimeout: queryTimeout},
+
+		tx: tx,
+	}
+}
+
+// WithTx runs fn in a transaction, the Models it gets run their queries on it.
+// The transaction is committed if fn returns nil and rolled back otherwise
+func (m Models) WithTx(ctx context.Context, fn func(Models) error) error {
+	if m.tx != nil {
+		return m.withSavepoint(ctx, fn)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
+	// rollback is a no-op once the tx has been committed
+	defer tx.Rollback()
+
+	txModels := m
+	txModels.Movies.DB = tx
+	txModels.Permissions.DB = tx
+	txModels.Users.DB = tx
+	txModels.Tokens.DB = tx
+
+	err = fn(txModels)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	return nil
+}
+
+// withSavepoint gives fn the same all or nothing result as WithTx inside m.tx. The
+// models already run on m.tx, so fn gets them as they are
+func (m Models) withSavepoint(ctx context.Context, fn func(Models) error) error {
+	_, err := m.tx.ExecContext(ctx, "SAVEPOINT models_with_tx")
+	if err != nil {
+		return err
+	}
+
+	err = fn(m)
+	if err != nil {
+		// this also makes m.tx usable again after a failed statement. ctx may be the
+		// reason fn failed, so it isn't used here
+		_, rollbackErr := m.tx.ExecContext(context.Background(), "ROLLBACK TO SAVEPOINT models_with_tx")
+		if rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("rollback to savepoint: %w", rollbackErr))
+		}
+		return err
+	}
+
+	_, err = m.tx.ExecContext(ctx, "RELEASE SAVEPOINT models_with_tx")
+	if err != nil {
+		return fmt.Errorf("release savepoint: %w", err)
+	}
+
+	return nil
 }
@@ -0,0 +1,96 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/souvikmndl/greenlight-api/internal/testhelpers"
+)
+
+func TestWithTxRollsBack(t *testing.T) {
+	// WithTx opens its own transaction, so this runs on the pool rather than on
+	// testhelpers.Tx. Nothing is committed since fn always fails
+	models := NewModels(testhelpers.DB(t), 5*time.Second)
+
+	email := fmt.Sprintf("rollback.%d@example.com", time.Now().UnixNano())
+
+	err := models.WithTx(context.Background(), func(txModels Models) error {
+		user := &User{Name: "Alice", Email: email}
+
+		err := user.Password.Set("pa55word1234")
+		if err != nil {
+			return err
+		}
+
+		err = txModels.Users.Insert(user)
+		if err != nil {
+			t.Fatalf("inserting the user: %v", err)
+		}
+
+		// the user exists inside the transaction
+		_, err = txModels.Users.GetByEmail(email)
+		if err != nil {
+			t.Fatalf("reading the user back in the transaction: %v", err)
+		}
+
+		// no user has id -1, so the tokens foreign key fails the insert
+		_, err = txModels.Tokens.New(-1, time.Hour, ScopeActivation)
+		return err
+	})
+	if err == nil {
+		t.Fatal("got no error from a failing token insert")
+	}
+
+	_, err = models.Users.GetByEmail(email)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got error %v looking up the user, want ErrRecordNotFound after the rollback", err)
+	}
+}
+
+func TestWithTxSavepoint(t *testing.T) {
+	models := newTestModels(t)
+
+	kept := &User{Name: "Alice", Email: fmt.Sprintf("savepoint.kept.%d@example.com", time.Now().UnixNano())}
+	dropped := &User{Name: "Bob", Email: fmt.Sprintf("savepoint.dropped.%d@example.com", time.Now().UnixNano())}
+
+	for _, user := range []*User{kept, dropped} {
+		err := user.Password.Set("pa55word1234")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := models.WithTx(context.Background(), func(txModels Models) error {
+		return txModels.Users.Insert(kept)
+	})
+	if err != nil {
+		t.Fatalf("got error %v from a succeeding fn", err)
+	}
+
+	err = models.WithTx(context.Background(), func(txModels Models) error {
+		err := txModels.Users.Insert(dropped)
+		if err != nil {
+			t.Fatalf("inserting the user: %v", err)
+		}
+
+		// the foreign key failure aborts the outer transaction until the savepoint is rolled back
+		_, err = txModels.Tokens.New(-1, time.Hour, ScopeActivation)
+		return err
+	})
+	if err == nil {
+		t.Fatal("got no error from a failing token insert")
+	}
+
+	_, err = models.Users.GetByEmail(kept.Email)
+	if err != nil {
+		t.Errorf("got error %v looking up the user from the released savepoint", err)
+	}
+
+	_, err = models.Users.GetByEmail(dropped.Email)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got error %v looking up the user, want ErrRecordNotFound after the rollback", err)
+	}
+}
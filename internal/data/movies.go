@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -17,18 +19,54 @@ type Movie struct {
 	CreatedAt time.Time `json:"-"`
 	Title     string    `json:"title"`
 	Year      int32     `json:"year,omitzero"`
-	Runtime   Runtime   `json:"runtime,omitzero"`
+	Runtime   *Runtime  `json:"runtime"` // nil when unknown, eg ongoing series
 	Genres    []string  `json:"genres,omitzero"`
+	PosterURL string    `json:"poster_url,omitzero"` // empty until a poster is uploaded
 	Version   int32     `json:"version"`
 }
 
+// NumericRuntimeMovie wraps a Movie so its runtime is written as a plain integer.
+// The outer Runtime field shadows the embedded one when encoding to JSON
+type NumericRuntimeMovie struct {
+	*Movie
+	Runtime *int32 `json:"runtime"`
+}
+
+// WithNumericRuntime returns the movie wrapped for numeric runtime output
+func (m *Movie) WithNumericRuntime() NumericRuntimeMovie {
+	numeric := NumericRuntimeMovie{Movie: m}
+	if m.Runtime != nil {
+		runtime := int32(*m.Runtime)
+		numeric.Runtime = &runtime
+	}
+	return numeric
+}
+
+// ErrDuplicateMovie is returned when a movie with the same title and year exists
+// and -enforce-unique-movies created the movies_title_year_key index
+var ErrDuplicateMovie = errors.New("duplicate movie")
+
+// movieTitleYearIndex is the unique index EnsureUniqueTitleYear creates
+const movieTitleYearIndex = "movies_title_year_key"
+
+// isDuplicateMovie reports whether err is a unique_violation (23505) of movieTitleYearIndex
+func isDuplicateMovie(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == movieTitleYearIndex
+}
+
 // MovieModel struct to perform CRUD operations on Movie table
 type MovieModel struct {
-	DB *sql.DB
+	DB DBTX
+	// QueryTimeout bounds how long each query may run
+	QueryTimeout time.Duration
 }
 
 // Insert creates a new movie in db
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
+	ctx, span := startSpan(ctx, "MovieModel.Insert", "INSERT")
+	defer span.End()
+
 	query := `
 		INSERT INTO movies (title, year, runtime, genres)
 		VALUES ($1, $2, $3, $4)
@@ -36,24 +74,97 @@ func (m MovieModel) Insert(movie *Movie) error {
 
 	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel() // deadline/timeout starts from right here
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		if isDuplicateMovie(err) {
+			return ErrDuplicateMovie
+		}
+		recordSpanError(span, err)
+		return err
+	}
+
+	return nil
 }
 
-// Get fetches a movie by id
-func (m MovieModel) Get(id int64) (*Movie, error) {
+// EnsureUniqueTitleYear creates a unique index on (title, year) if there isn't one
+// already. It fails when the table already holds duplicates. The index outlives the
+// flag asking for it, drop it by hand to allow duplicates again
+func (m MovieModel) EnsureUniqueTitleYear() error {
+	query := fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %s ON movies (title, year)`, movieTitleYearIndex)
+
+	// building the index scans the whole table, so it gets longer than a single query
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		// building the index fails with the same unique_violation on existing duplicates
+		if isDuplicateMovie(err) {
+			return errors.New("movies table already has duplicate title and year pairs, remove them before enabling -enforce-unique-movies")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// InsertBatch inserts movies through a single prepared statement, setting the ids,
+// created_at and version on each. Call it inside Models.WithTx so either every movie
+// is created or none are
+func (m MovieModel) InsertBatch(ctx context.Context, movies []*Movie) error {
+	ctx, span := startSpan(ctx, "MovieModel.InsertBatch", "INSERT")
+	defer span.End()
+
+	query := `
+		INSERT INTO movies (title, year, runtime, genres)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	// the timeout covers the whole batch, so it grows by a query's worth every 100 movies
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout*time.Duration(1+len(movies)/100))
+	defer cancel()
+
+	stmt, err := m.DB.PrepareContext(ctx, query)
+	if err != nil {
+		recordSpanError(span, err)
+		return err
+	}
+	defer stmt.Close()
+
+	for _, movie := range movies {
+		args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+		err = stmt.QueryRowContext(ctx, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+		if err != nil {
+			if isDuplicateMovie(err) {
+				return ErrDuplicateMovie
+			}
+			recordSpanError(span, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get fetches a movie by id, the query is cancelled if ctx is done before it finishes
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
+	ctx, span := startSpan(ctx, "MovieModel.Get", "SELECT")
+	defer span.End()
+
 	query := `
-		SELECT id, created_at, title, year, runtime, genres, version
+		SELECT id, created_at, title, year, runtime, genres, poster_url, version
 		FROM movies
 		WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	var movie Movie
@@ -64,9 +175,12 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
+		&movie.PosterURL,
 		&movie.Version,
 	)
 	if err != nil {
+		recordSpanError(span, err)
+
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
@@ -95,12 +209,14 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Version, // to handle data race condition
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
+		case isDuplicateMovie(err):
+			return ErrDuplicateMovie
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
@@ -121,7 +237,7 @@ func (m MovieModel) Delete(id int64) error {
 			DELETE FROM movies
 			WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
 	defer cancel()
 
 	result, err := m.DB.ExecContext(ctx, query, id)
@@ -141,23 +257,122 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-// GetAll resturns a list of movies based on the filters
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// Export calls fn with every movie in id order, reading them from a cursor so the
+// whole table is never held in memory. There is no QueryTimeout here since a big
+// export can legitimately run long, ctx is what stops it. An error from fn stops
+// the export and is returned as is
+func (m MovieModel) Export(ctx context.Context, fn func(*Movie) error) error {
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, poster_url, version
+		FROM movies
+		ORDER BY id`
+
+	ctx, span := startSpan(ctx, "MovieModel.Export", "SELECT")
+	defer span.End()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		recordSpanError(span, err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.PosterURL,
+			&movie.Version,
+		)
+		if err != nil {
+			recordSpanError(span, err)
+			return err
+		}
+
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		recordSpanError(span, err)
+		return err
+	}
+
+	return nil
+}
+
+// SetPoster records the url the movie's poster is served from and bumps the
+// version, so cached representations of the movie are invalidated
+func (m MovieModel) SetPoster(id int64, url string) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+			UPDATE movies
+			SET poster_url = $2, version = version + 1
+			WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, url)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAll resturns a list of movies based on the filters, the query is cancelled
+// if ctx is done before it finishes. Only movies created in [createdAfter, createdBefore)
+// are returned, a zero time leaves that end of the range open
+func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, createdAfter, createdBefore time.Time, filters Filters) ([]*Movie, Metadata, error) {
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, poster_url, version
 		FROM movies
 		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 		AND (genres @> $2 OR $2 = '{}')
+		AND (created_at >= $3 OR $3 IS NULL)
+		AND (created_at < $4 OR $4 IS NULL)
 		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "MovieModel.GetAll", "SELECT")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
+	args := []any{
+		title,
+		pq.Array(genres),
+		sql.NullTime{Time: createdAfter, Valid: !createdAfter.IsZero()},
+		sql.NullTime{Time: createdBefore, Valid: !createdBefore.IsZero()},
+		filters.limit(),
+		filters.offset(),
+	}
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
+		recordSpanError(span, err)
 		return nil, Metadata{}, err
 	}
 	defer rows.Close()
@@ -176,6 +391,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
+			&movie.PosterURL,
 			&movie.Version,
 		)
 		if err != nil {
@@ -185,6 +401,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	}
 
 	if err = rows.Err(); err != nil {
+		recordSpanError(span, err)
 		return nil, Metadata{}, err
 	}
 
@@ -193,20 +410,167 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	return movies, metadata, nil
 }
 
-// ValidateMovies performs validation checks on API input payload
-func ValidateMovies(v *validator.Validator, movie *Movie) {
-	v.Check(movie.Title != "", "title", "must be provided")
-	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
+// GetSimilar returns up to limit movies sharing a genre with movie or a word in its
+// title, best matches first: title rank (ts_rank) plus the number of shared genres.
+// movie itself is never part of the result
+func (m MovieModel) GetSimilar(ctx context.Context, movie *Movie, limit int) ([]*Movie, error) {
+	// the title words are OR-ed together, any one of them is enough to count as similar
+	query := `
+		WITH q AS (
+			SELECT to_tsquery('simple', replace(plainto_tsquery('simple', $3)::text, ' & ', ' | ')) AS query
+		)
+		SELECT movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.poster_url, movies.version
+		FROM movies, q
+		WHERE movies.id <> $1
+		AND (movies.genres && $2 OR to_tsvector('simple', movies.title) @@ q.query)
+		ORDER BY ts_rank(to_tsvector('simple', movies.title), q.query)
+			+ cardinality(ARRAY(SELECT unnest(movies.genres) INTERSECT SELECT unnest($2::text[]))) DESC,
+			movies.id ASC
+		LIMIT $4`
+
+	ctx, span := startSpan(ctx, "MovieModel.GetSimilar", "SELECT")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movie.ID, pq.Array(movie.Genres), movie.Title, limit)
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
 
-	v.Check(movie.Year != 0, "year", "must be provided")
-	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
+	for rows.Next() {
+		var similar Movie
 
-	v.Check(movie.Runtime != 0, "runtime", "must be provided")
-	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
+		err := rows.Scan(
+			&similar.ID,
+			&similar.CreatedAt,
+			&similar.Title,
+			&similar.Year,
+			&similar.Runtime,
+			pq.Array(&similar.Genres),
+			&similar.PosterURL,
+			&similar.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &similar)
+	}
+
+	if err = rows.Err(); err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// MovieStats summarises the whole movies collection
+type MovieStats struct {
+	TotalMovies    int            `json:"total_movies"`
+	AverageRuntime float64        `json:"average_runtime"`
+	MinYear        int32          `json:"min_year"`
+	MaxYear        int32          `json:"max_year"`
+	Genres         map[string]int `json:"genres"`
+}
+
+// Stats returns the movie count, runtime average, year range and the number of
+// movies in each genre. An empty table gives zeroes rather than nulls
+func (m MovieModel) Stats() (*MovieStats, error) {
+	query := `
+		SELECT count(*), COALESCE(avg(runtime), 0), COALESCE(min(year), 0), COALESCE(max(year), 0)
+		FROM movies`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
+	defer cancel()
+
+	stats := MovieStats{Genres: map[string]int{}}
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&stats.TotalMovies, &stats.AverageRuntime, &stats.MinYear, &stats.MaxYear)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT genre, count(*)
+		FROM movies, unnest(genres) AS genre
+		GROUP BY genre`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			genre string
+			count int
+		)
+
+		err := rows.Scan(&genre, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		stats.Genres[genre] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// NormalizeGenres trims, lowercases and collapses inner whitespace in every genre,
+// then drops the duplicates this creates, eg "Sci-Fi" and " sci-fi" become one
+// "sci-fi". Keeping stored genres canonical is what makes the @> filter in GetAll reliable
+func NormalizeGenres(genres []string) []string {
+	if genres == nil {
+		return nil
+	}
+
+	normalized := make([]string, 0, len(genres))
+
+	for _, genre := range genres {
+		genre = strings.ToLower(strings.Join(strings.Fields(genre), " "))
+		if !slices.Contains(normalized, genre) {
+			normalized = append(normalized, genre)
+		}
+	}
+
+	return normalized
+}
+
+// MinMovieYear is the default earliest year a movie may have, the year of the first film
+const MinMovieYear = 1888
+
+// ValidateMovie performs validation checks on API input payload, it doesn't modify
+// movie. Callers run the genres through NormalizeGenres first, so case and spacing
+// variants count as duplicates and every handler stores the same canonical genres.
+// The year must fall between minYear and the current year
+func ValidateMovie(v *validator.Validator, movie *Movie, minYear int32) {
+	v.CheckCode(movie.Title != "", "title", validator.CodeRequired, "must be provided")
+	v.CheckCode(len(movie.Title) <= 500, "title", validator.CodeTooLong, "must not be more than 500 bytes long")
+
+	v.CheckCode(movie.Year != 0, "year", validator.CodeRequired, "must be provided")
+	v.CheckCode(movie.Year >= minYear, "year", validator.CodeTooSmall, fmt.Sprintf("must not be earlier than %d", minYear))
+	v.CheckCode(movie.Year <= int32(time.Now().Year()), "year", validator.CodeTooLarge, "must not be in the future")
+
+	// runtime is optional, but has to be positive when given
+	if movie.Runtime != nil {
+		v.CheckCode(*movie.Runtime > 0, "runtime", validator.CodeTooSmall, "must be a positive integer")
+	}
 
-	v.Check(movie.Genres != nil, "genres", "must be provided")
-	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
-	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+	v.CheckCode(movie.Genres != nil, "genres", validator.CodeRequired, "must be provided")
+	v.CheckCode(len(movie.Genres) >= 1, "genres", validator.CodeTooShort, "must contain at least 1 genre")
+	v.CheckCode(len(movie.Genres) <= 5, "genres", validator.CodeTooLong, "must not contain more than 5 genres")
+	v.CheckCode(validator.Unique(movie.Genres), "genres", validator.CodeDuplicate, "must not contain duplicate values")
+	v.CheckCode(!slices.Contains(movie.Genres, ""), "genres", validator.CodeRequired, "must not contain empty values")
 }
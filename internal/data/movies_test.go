@@ -0,0 +1,441 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/souvikmndl/greenlight-api/internal/validator"
+)
+
+func TestMovieModelRoundTrip(t *testing.T) {
+	movies := newTestModels(t).Movies
+	ctx := context.Background()
+
+	runtime := Runtime(107)
+	movie := &Movie{
+		Title:   "Moana",
+		Year:    2016,
+		Runtime: &runtime,
+		Genres:  []string{"animation", "adventure"},
+	}
+
+	err := movies.Insert(ctx, movie)
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if movie.ID < 1 || movie.Version != 1 || movie.CreatedAt.IsZero() {
+		t.Fatalf("Insert set id %d, version %d, created_at %v", movie.ID, movie.Version, movie.CreatedAt)
+	}
+
+	got, err := movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Title != movie.Title || got.Year != movie.Year || got.Runtime == nil || *got.Runtime != runtime || !slices.Equal(got.Genres, movie.Genres) {
+		t.Fatalf("Get returned %+v, want %+v", got, movie)
+	}
+
+	got.Title = "Moana (2016)"
+	got.Runtime = nil
+
+	err = movies.Update(got)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got.Version != 2 {
+		t.Errorf("Update set version %d, want 2", got.Version)
+	}
+
+	updated, err := movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatalf("Get after Update: %v", err)
+	}
+	if updated.Title != "Moana (2016)" || updated.Runtime != nil || updated.Version != 2 {
+		t.Errorf("Get after Update returned %+v", updated)
+	}
+
+	err = movies.Delete(movie.ID)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	_, err = movies.Get(ctx, movie.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Get after Delete returned %v, want ErrRecordNotFound", err)
+	}
+
+	err = movies.Delete(movie.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("second Delete returned %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestMovieModelGetAllCreatedRange(t *testing.T) {
+	movies := newTestModels(t).Movies
+	ctx := context.Background()
+
+	date := func(year int) time.Time {
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	// now() is fixed for the whole transaction, so created_at is set by hand
+	for _, year := range []int{2020, 2022, 2024} {
+		movie := &Movie{Title: fmt.Sprintf("Rangetest %d", year), Year: 2016, Genres: []string{"drama"}}
+
+		err := movies.Insert(ctx, movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = movies.DB.ExecContext(ctx, `UPDATE movies SET created_at = $1 WHERE id = $2`, date(year), movie.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		after  time.Time
+		before time.Time
+		want   []string
+	}{
+		{"no bounds", time.Time{}, time.Time{}, []string{"Rangetest 2020", "Rangetest 2022", "Rangetest 2024"}},
+		{"only after", date(2021), time.Time{}, []string{"Rangetest 2022", "Rangetest 2024"}},
+		{"only before", time.Time{}, date(2023), []string{"Rangetest 2020", "Rangetest 2022"}},
+		{"both", date(2021), date(2023), []string{"Rangetest 2022"}},
+		{"after is inclusive", date(2022), time.Time{}, []string{"Rangetest 2022", "Rangetest 2024"}},
+		{"before is exclusive", time.Time{}, date(2022), []string{"Rangetest 2020"}},
+	}
+
+	filters := Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, metadata, err := movies.GetAll(ctx, "rangetest", []string{}, tt.after, tt.before, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			titles := make([]string, len(got))
+			for i, movie := range got {
+				titles[i] = movie.Title
+			}
+
+			if !slices.Equal(titles, tt.want) {
+				t.Errorf("got %v, want %v", titles, tt.want)
+			}
+			if metadata.TotalRecords != len(tt.want) {
+				t.Errorf("got %d total records, want %d", metadata.TotalRecords, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestNormalizeGenres(t *testing.T) {
+	tests := []struct {
+		name   string
+		genres []string
+		want   []string
+	}{
+		{"nil", nil, nil},
+		{"empty", []string{}, []string{}},
+		{"already canonical", []string{"drama", "sci-fi"}, []string{"drama", "sci-fi"}},
+		{"case", []string{"Sci-Fi", "DRAMA"}, []string{"sci-fi", "drama"}},
+		{"spacing", []string{"  science   fiction ", "drama\t"}, []string{"science fiction", "drama"}},
+		{"duplicates keep the first", []string{"Sci-Fi", "drama", " sci-fi"}, []string{"sci-fi", "drama"}},
+		{"blank stays blank", []string{"drama", "  "}, []string{"drama", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NormalizeGenres(tt.genres)
+
+			if (got == nil) != (tt.want == nil) || !slices.Equal(got, tt.want) {
+				t.Errorf("NormalizeGenres(%q) = %q, want %q", tt.genres, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMovieLeavesMovieAlone(t *testing.T) {
+	genres := []string{"Sci-Fi", " Drama "}
+	movie := &Movie{Title: "Arrival", Year: 2016, Genres: slices.Clone(genres)}
+
+	v := validator.New()
+	ValidateMovie(v, movie, MinMovieYear)
+
+	if !v.Valid() {
+		t.Fatalf("got errors %v", v.Errors)
+	}
+	if !slices.Equal(movie.Genres, genres) {
+		t.Errorf("ValidateMovie changed the genres to %q", movie.Genres)
+	}
+}
+
+func TestMovieWithoutRuntime(t *testing.T) {
+	t.Run("decoding", func(t *testing.T) {
+		runtime := Runtime(57)
+
+		tests := []struct {
+			name string
+			json string
+			want *Runtime
+		}{
+			{"missing", `{"title": "Severance"}`, nil},
+			{"null", `{"title": "Severance", "runtime": null}`, nil},
+			{"given", `{"title": "Severance", "runtime": "57 mins"}`, &runtime},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var input struct {
+					Title   string   `json:"title"`
+					Runtime *Runtime `json:"runtime"`
+				}
+
+				err := json.Unmarshal([]byte(tt.json), &input)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if (input.Runtime == nil) != (tt.want == nil) || (tt.want != nil && *input.Runtime != *tt.want) {
+					t.Errorf("got runtime %v, want %v", input.Runtime, tt.want)
+				}
+			})
+		}
+	})
+
+	t.Run("encoding", func(t *testing.T) {
+		movie := &Movie{ID: 1, Title: "Severance", Year: 2022, Genres: []string{"drama"}, Version: 1}
+
+		for name, value := range map[string]any{"minutes": movie, "numeric": movie.WithNumericRuntime()} {
+			js, err := json.Marshal(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(string(js), `"runtime":null`) {
+				t.Errorf("%s: got %s, want runtime null", name, js)
+			}
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		movie := &Movie{Title: "Severance", Year: 2022, Genres: []string{"drama"}}
+
+		v := validator.New()
+		ValidateMovie(v, movie, MinMovieYear)
+		if !v.Valid() {
+			t.Errorf("no runtime: got errors %v", v.Errors)
+		}
+
+		// a runtime that is given still has to be positive
+		movie.Runtime = new(Runtime)
+
+		v = validator.New()
+		ValidateMovie(v, movie, MinMovieYear)
+		if v.Errors["runtime"] != "must be a positive integer" {
+			t.Errorf("zero runtime: got errors %v", v.Errors)
+		}
+	})
+}
+
+func TestMovieModelInsertWithoutRuntime(t *testing.T) {
+	movies := newTestModels(t).Movies
+	ctx := context.Background()
+
+	movie := &Movie{Title: "Severance", Year: 2022, Genres: []string{"drama"}}
+
+	err := movies.Insert(ctx, movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Runtime != nil {
+		t.Errorf("got runtime %d, want nil", *got.Runtime)
+	}
+}
+
+func TestMovieModelPosterURL(t *testing.T) {
+	movies := newTestModels(t).Movies
+	ctx := context.Background()
+
+	movie := &Movie{Title: "Postertest", Year: 2016, Genres: []string{"animation"}}
+
+	err := movies.Insert(ctx, movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := fmt.Sprintf("/v1/movies/%d/poster", movie.ID)
+
+	err = movies.SetPoster(movie.ID, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.PosterURL != url {
+		t.Errorf("Get: got poster url %q, want %q", got.PosterURL, url)
+	}
+
+	filters := Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+
+	all, _, err := movies.GetAll(ctx, "postertest", []string{}, time.Time{}, time.Time{}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].PosterURL != url {
+		t.Errorf("GetAll: got %+v, want the movie with poster url %q", all, url)
+	}
+
+	var exported string
+	err = movies.Export(ctx, func(m *Movie) error {
+		if m.ID == movie.ID {
+			exported = m.PosterURL
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported != url {
+		t.Errorf("Export: got poster url %q, want %q", exported, url)
+	}
+}
+
+func TestValidateMovieYear(t *testing.T) {
+	thisYear := int32(time.Now().Year())
+
+	tests := []struct {
+		name    string
+		year    int32
+		minYear int32
+		wantErr string
+	}{
+		{"first film", 1888, MinMovieYear, ""},
+		{"before the default floor", 1700, MinMovieYear, "must not be earlier than 1888"},
+		{"lowered floor", 1700, 1700, ""},
+		{"below a lowered floor", 1699, 1700, "must not be earlier than 1700"},
+		{"this year", thisYear, MinMovieYear, ""},
+		{"next year", thisYear + 1, MinMovieYear, "must not be in the future"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movie := &Movie{Title: "Le Bourgeois gentilhomme", Year: tt.year, Genres: []string{"comedy"}}
+
+			v := validator.New()
+			ValidateMovie(v, movie, tt.minYear)
+
+			if got := v.Errors["year"]; got != tt.wantErr {
+				t.Errorf("got year error %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMovieModelInsertEarlyYear(t *testing.T) {
+	movies := newTestModels(t).Movies
+
+	// the database must not hold on to the old 1888 floor
+	movie := &Movie{Title: "Le Bourgeois gentilhomme", Year: 1700, Genres: []string{"comedy"}}
+
+	err := movies.Insert(context.Background(), movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsDuplicateMovie(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"title and year index", &pq.Error{Code: "23505", Constraint: "movies_title_year_key"}, true},
+		{"wrapped", fmt.Errorf("insert: %w", &pq.Error{Code: "23505", Constraint: "movies_title_year_key"}), true},
+		{"another unique index", &pq.Error{Code: "23505", Constraint: "movies_pkey"}, false},
+		{"check violation", &pq.Error{Code: "23514", Constraint: "movies_title_year_key"}, false},
+		{"not a pq error", errors.New("duplicate"), false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDuplicateMovie(tt.err); got != tt.want {
+				t.Errorf("isDuplicateMovie(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMovieModelInsertDuplicate(t *testing.T) {
+	movies := newTestModels(t).Movies
+	ctx := context.Background()
+
+	// inside the test transaction, so the index is gone again afterwards
+	err := movies.EnsureUniqueTitleYear()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newMovie := func(year int32) *Movie {
+		return &Movie{Title: "Duplicatetest", Year: year, Genres: []string{"drama"}}
+	}
+
+	err = movies.Insert(ctx, newMovie(2016))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a remake in another year is still allowed
+	remake := newMovie(2020)
+	err = movies.Insert(ctx, remake)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed statement aborts the transaction, so each attempt runs in a savepoint
+	attempt := func(name string, fn func() error) {
+		t.Helper()
+
+		_, err := movies.DB.ExecContext(ctx, `SAVEPOINT duplicate`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = fn()
+		if !errors.Is(err, ErrDuplicateMovie) {
+			t.Errorf("%s: got error %v, want ErrDuplicateMovie", name, err)
+		}
+
+		_, err = movies.DB.ExecContext(ctx, `ROLLBACK TO SAVEPOINT duplicate`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	attempt("Insert", func() error {
+		return movies.Insert(ctx, newMovie(2016))
+	})
+
+	attempt("Update", func() error {
+		remake.Year = 2016
+		return movies.Update(remake)
+	})
+}
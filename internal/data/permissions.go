@@ -2,14 +2,18 @@ package data
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/lib/pq"
+	"github.com/souvikmndl/greenlight-api/internal/validator"
 	"golang.org/x/exp/slices"
 )
 
+// PermissionCodes lists every permission code that can be granted to a user
+var PermissionCodes = []string{"movies:read", "movies:write", "permissions:write", "users:read"}
+
 // Permissions will contains user permissions
 type Permissions []string
 
@@ -20,7 +24,42 @@ func (p Permissions) Include(code string) bool {
 
 // PermissionModel contains queries for user permissions
 type PermissionModel struct {
-	DB *sql.DB
+	DB DBTX
+	// QueryTimeout bounds how long each query may run
+	QueryTimeout time.Duration
+}
+
+// GetAll returns every permission code stored in the permissions table
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `SELECT code FROM permissions ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
 }
 
 // GetAllForuser returns all permission code for a specific user
@@ -29,15 +68,14 @@ func (m PermissionModel) GetAllForuser(userID int64) (Permissions, error) {
         SELECT permissions.code
         FROM permissions
         INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-        INNER JOIN users ON user_permissions.user_id = users.id
+        INNER JOIN users ON users_permissions.user_id = users.id
         WHERE users.id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
 	if err != nil {
-		fmt.Println("39 mw error", err.Error())
 		return nil, err
 	}
 	defer rows.Close()
@@ -49,7 +87,6 @@ func (m PermissionModel) GetAllForuser(userID int64) (Permissions, error) {
 
 		err := rows.Scan(&permission)
 		if err != nil {
-			fmt.Println("mw err", err.Error())
 			return nil, err
 		}
 
@@ -62,15 +99,49 @@ func (m PermissionModel) GetAllForuser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
-// AddForUser adds specific permission codes for a given user
+// AddForUser adds specific permission codes for a given user, codes the
+// user already holds are skipped so granting them again is not an error
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions
-		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		// foreign_key_violation, there is no user with this id
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrRecordNotFound
+		}
+		return err
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	return nil
+}
+
+// RemoveForUser revokes specific permission codes from a given user, codes
+// the user doesn't hold are ignored
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+		DELETE FROM users_permissions
+		WHERE user_id = $1 AND permission_id IN (SELECT id FROM permissions WHERE code = ANY($2))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
+
+// ValidatePermissionCodes checks that codes is not empty and only holds known permission codes
+func ValidatePermissionCodes(v *validator.Validator, codes []string) {
+	v.CheckCode(len(codes) >= 1, "permissions", validator.CodeRequired, "must contain at least 1 permission")
+
+	for _, code := range codes {
+		v.CheckCode(validator.PermittedValue(code, PermissionCodes...), "permissions", validator.CodeNotPermitted, fmt.Sprintf("unknown permission %q", code))
+	}
+}
@@ -3,6 +3,8 @@ package data
 import (
 	"errors"
 	"fmt"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
 )
@@ -12,6 +14,13 @@ type Runtime int32
 
 var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
 
+const (
+	// RuntimeFormatMinutes is the default output format for runtimes, eg "107 mins"
+	RuntimeFormatMinutes = "minutes"
+	// RuntimeFormatNumeric outputs runtimes as a plain number of minutes, eg 107
+	RuntimeFormatNumeric = "numeric"
+)
+
 /*
 MarshalJSON ([]byte, error) --> Go has its own version for every type. This dictates how a type should be
 converted to JSON format. We will use the Runtime type (of int32) inside the Movie struct to represent the
@@ -44,17 +53,61 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 		return ErrInvalidRuntimeFormat
 	}
 
-	parts := strings.Split(unquotedJSONValue, " ")
-	if len(parts) != 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
-	}
-
-	i, err := strconv.ParseInt(parts[0], 10, 32)
+	runtime, err := ParseRuntime(unquotedJSONValue)
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		return err
 	}
 
-	*r = Runtime(i) // dereference and store the value
+	*r = runtime // dereference and store the value
 
 	return nil
 }
+
+// isoRuntimeRX matches the hours and minutes ISO-8601 durations used for runtimes, eg "PT1H47M"
+var isoRuntimeRX = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?$`)
+
+// ParseRuntime accepts a runtime either as "107 mins" or as an ISO-8601 duration
+// like "PT1H47M", "PT2H" or "PT47M". It returns ErrInvalidRuntimeFormat when the
+// value is in neither format or isn't a positive number of minutes
+func ParseRuntime(value string) (Runtime, error) {
+	// Fields splits on any run of whitespace, so "107  mins" or " 107 mins " still parse
+	parts := strings.Fields(value)
+	if len(parts) == 2 && parts[1] == "mins" {
+		i, err := strconv.ParseInt(parts[0], 10, 32)
+		if err != nil || i < 1 {
+			return 0, ErrInvalidRuntimeFormat
+		}
+
+		return Runtime(i), nil
+	}
+
+	matches := isoRuntimeRX.FindStringSubmatch(strings.TrimSpace(value))
+	// "PT" alone matches the pattern but has no components
+	if matches == nil || (matches[1] == "" && matches[2] == "") {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	var minutes int64
+
+	if matches[1] != "" {
+		hours, err := strconv.ParseInt(matches[1], 10, 32)
+		if err != nil {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		minutes = hours * 60
+	}
+
+	if matches[2] != "" {
+		m, err := strconv.ParseInt(matches[2], 10, 32)
+		if err != nil {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		minutes += m
+	}
+
+	if minutes < 1 || minutes > math.MaxInt32 {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	return Runtime(minutes), nil
+}
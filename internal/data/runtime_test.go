@@ -0,0 +1,96 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestRuntimeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Runtime
+		wantErr error
+	}{
+		{"minutes", `"107 mins"`, 107, nil},
+		{"extra inner whitespace", `"107  mins"`, 107, nil},
+		{"surrounding whitespace", `" 107 mins "`, 107, nil},
+		{"zero", `"0 mins"`, 0, ErrInvalidRuntimeFormat},
+		{"negative", `"-1 mins"`, 0, ErrInvalidRuntimeFormat},
+		{"wrong unit", `"107 minutes"`, 0, ErrInvalidRuntimeFormat},
+		{"bare integer", `107`, 0, ErrInvalidRuntimeFormat},
+		{"quoted bare integer", `"107"`, 0, ErrInvalidRuntimeFormat},
+		{"empty", `""`, 0, ErrInvalidRuntimeFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r Runtime
+			err := json.Unmarshal([]byte(tt.json), &r)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if r != tt.want {
+				t.Errorf("got runtime %d, want %d", r, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRuntime(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Runtime
+		wantErr error
+	}{
+		{"107 mins", 107, nil},
+		{"PT1H47M", 107, nil},
+		{"PT2H", 120, nil},
+		{"PT47M", 47, nil},
+		{"PT0H47M", 47, nil},
+		{"PT90M", 90, nil},
+		{" PT2H ", 120, nil},
+		{"PT", 0, ErrInvalidRuntimeFormat},
+		{"PT0M", 0, ErrInvalidRuntimeFormat},
+		{"PT47M1H", 0, ErrInvalidRuntimeFormat},
+		{"PT1H47M30S", 0, ErrInvalidRuntimeFormat},
+		{"P1DT2H", 0, ErrInvalidRuntimeFormat},
+		{"pt2h", 0, ErrInvalidRuntimeFormat},
+		{"PT1H 47 mins", 0, ErrInvalidRuntimeFormat},
+		{"107", 0, ErrInvalidRuntimeFormat},
+		{"PT99999999999H", 0, ErrInvalidRuntimeFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseRuntime(tt.value)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got runtime %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuntimeRoundTripISO(t *testing.T) {
+	// an ISO-8601 runtime is accepted but always written back in minutes
+	var r Runtime
+
+	err := json.Unmarshal([]byte(`"PT1H47M"`), &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	js, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(js) != `"107 mins"` {
+		t.Errorf("got %s, want %q", js, "107 mins")
+	}
+}
@@ -0,0 +1,16 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/souvikmndl/greenlight-api/internal/testhelpers"
+)
+
+// newTestModels returns Models running on a transaction of the test database,
+// rolled back when t finishes. t is skipped when there is no test database
+func newTestModels(t *testing.T) Models {
+	t.Helper()
+
+	return NewModelsTx(testhelpers.Tx(t), 5*time.Second)
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
 	"time"
 
 	"github.com/souvikmndl/greenlight-api/internal/validator"
@@ -15,6 +14,8 @@ const (
 	ScopeActivation = "activation"
 	// ScopeAuthentication to authenticate user token
 	ScopeAuthentication = "authentication"
+	// ScopePasswordReset to reset a user's password
+	ScopePasswordReset = "password-reset"
 )
 
 // Token struct holds data for an individual token, including plaintext and hashed version
@@ -28,7 +29,9 @@ type Token struct {
 
 // TokenModel struct to model queries on
 type TokenModel struct {
-	DB *sql.DB
+	DB DBTX
+	// QueryTimeout bounds how long each query may run
+	QueryTimeout time.Duration
 }
 
 // generateToken creates the plaintext token, hash of token, expiry and scope
@@ -47,9 +50,9 @@ func generateToken(userID int64, ttl time.Duration, scope string) *Token {
 }
 
 // ValidateTokenPlaintext checks whether plaintext token is provided and is exactly 26 characters long
-func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
-	v.Check(tokenPlaintext != "", "token", "must be provided")
-	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+func ValidateTokenPlaintext(v validator.Checker, tokenPlaintext string) {
+	v.CheckCode(tokenPlaintext != "", "token", validator.CodeRequired, "must be provided")
+	v.CheckCode(len(tokenPlaintext) == 26, "token", validator.CodeInvalid, "must be 26 bytes long")
 }
 
 // New generates a new token for a user and scope, inserts it into db and returns it
@@ -68,7 +71,7 @@ func (m TokenModel) Insert(token *Token) error {
 
 	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
@@ -81,9 +84,42 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	 DELETE FROM tokens 
 	 WHERE scope = $1 AND user_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteExpired deletes every token past its expiry time, for any user and scope,
+// and returns how many were removed
+func (m TokenModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE expiry < now()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteForPlaintext deletes the single token matching a plaintext token and scope
+func (m TokenModel) DeleteForPlaintext(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope)
+	return err
+}
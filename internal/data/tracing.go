@@ -0,0 +1,37 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer comes from the global provider, which stays a no-op unless the api sets one up
+var tracer = otel.Tracer("github.com/souvikmndl/greenlight-api/internal/data")
+
+// startSpan starts a span for a model method as a child of whatever span is in ctx,
+// operation is the SQL statement kind eg "SELECT"
+func startSpan(ctx context.Context, name, operation string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation.name", operation),
+		),
+	)
+}
+
+// recordSpanError marks span as failed, no rows isn't treated as a failure
+func recordSpanError(span trace.Span, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -20,7 +20,9 @@ var (
 
 // UserModel struct to isolate db queries against user table
 type UserModel struct {
-	DB *sql.DB
+	DB DBTX
+	// QueryTimeout bounds how long each query may run
+	QueryTimeout time.Duration
 }
 
 // User represents users table in db
@@ -68,27 +70,36 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 }
 
 // ValidateEmail validates email is not empty and has proper format
-func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+func ValidateEmail(v validator.Checker, email string) {
+	v.CheckCode(email != "", "email", validator.CodeRequired, "must be provided")
+	v.CheckCode(validator.Matches(email, validator.EmailRX), "email", validator.CodeInvalid, "must be a valid email address")
 }
 
-// ValidatePasswordPlainText validates length of password
-func ValidatePasswordPlainText(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more that 72 bytes long")
+// ValidateEmailRFC is ValidateEmail using validator.EmailRFC instead of EmailRX, so
+// internationalized domains and quoted local parts are accepted
+func ValidateEmailRFC(v validator.Checker, email string) {
+	v.CheckCode(email != "", "email", validator.CodeRequired, "must be provided")
+	v.CheckCode(validator.EmailRFC(email), "email", validator.CodeInvalid, "must be a valid email address")
 }
 
-// ValidateUser performs entire user validation
-func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+// ValidatePasswordPlaintext validates length of password. bcrypt silently truncates
+// anything beyond 72 bytes, so longer passwords are rejected rather than accepted
+func ValidatePasswordPlaintext(v validator.Checker, password string) {
+	v.CheckCode(password != "", "password", validator.CodeRequired, "must be provided")
+	v.CheckCode(len(password) >= 8, "password", validator.CodeTooShort, "must be at least 8 bytes long")
+	v.CheckCode(len(password) <= 72, "password", validator.CodeTooLong, "must not be more than 72 bytes long")
+}
+
+// ValidateUser performs entire user validation, the email is checked with
+// validateEmail, either ValidateEmail or ValidateEmailRFC
+func ValidateUser(v validator.Checker, user *User, validateEmail func(validator.Checker, string)) {
+	v.CheckCode(user.Name != "", "name", validator.CodeRequired, "must be provided")
+	v.CheckCode(len(user.Name) <= 500, "name", validator.CodeTooLong, "must not be more than 500 bytes long")
 
-	ValidateEmail(v, user.Email)
+	validateEmail(v, user.Email)
 
 	if user.Password.plaintext != nil {
-		ValidatePasswordPlainText(v, *user.Password.plaintext)
+		ValidatePasswordPlaintext(v, *user.Password.plaintext)
 	}
 
 	// if its true, it means logic error in code
@@ -107,7 +118,7 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
@@ -131,7 +142,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -156,6 +167,44 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+// GetByID fetches one user from db by id
+func (m UserModel) GetByID(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT id, created_at, name, email, password_hash, activated, version
+        FROM users
+        WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 // Update updates various fields for a user. We use version to help prevent race conditions
 func (m UserModel) Update(user *User) error {
 	query := `
@@ -173,7 +222,7 @@ func (m UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -208,7 +257,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error)
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), m.QueryTimeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
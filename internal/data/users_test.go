@@ -0,0 +1,58 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+// newTestUser inserts an unactivated user with the given email
+func newTestUser(t *testing.T, models Models, email string) *User {
+	t.Helper()
+
+	user := &User{Name: "Alice", Email: email}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func TestUserModelUpdateStaleVersion(t *testing.T) {
+	models := newTestModels(t)
+
+	user := newTestUser(t, models, "alice.stale@example.com")
+
+	// two requests read the user at the same version
+	stale := *user
+
+	user.Activated = true
+	err := models.Users.Update(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Version != stale.Version+1 {
+		t.Errorf("got version %d after the update, want %d", user.Version, stale.Version+1)
+	}
+
+	// the second still holds the old version, it must not overwrite the first
+	stale.Name = "Mallory"
+	err = models.Users.Update(&stale)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Fatalf("got error %v, want ErrEditConflict", err)
+	}
+
+	stored, err := models.Users.GetByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Name != "Alice" || !stored.Activated || stored.Version != user.Version {
+		t.Errorf("got name %q activated %t version %d, want the first update to stand", stored.Name, stored.Activated, stored.Version)
+	}
+}
@@ -0,0 +1,69 @@
+package i18n
+
+import "golang.org/x/text/language"
+
+// Default is the catalog used by the api
+var Default = New(map[language.Tag]map[string]string{
+	language.Spanish: spanish,
+})
+
+var spanish = map[string]string{
+	// error responses
+	"the server encountered a problem and could not process your request":   "el servidor encontró un problema y no pudo procesar su solicitud",
+	"the requested resource could not be found":                             "no se pudo encontrar el recurso solicitado",
+	"the {0} method is not supported for this resource":                     "el método {0} no está soportado para este recurso",
+	"unable to update the record due to an edit conflict, please try again": "no se pudo actualizar el registro por un conflicto de edición, inténtelo de nuevo",
+	"the resource does not match the expected version":                      "el recurso no coincide con la versión esperada",
+	"the server is down for maintenance, please try again later":            "el servidor está en mantenimiento, inténtelo más tarde",
+	"the server is read-only for maintenance, please try again later":       "el servidor está en modo de solo lectura por mantenimiento, inténtelo más tarde",
+	"rate limit exceeded":                                                              "límite de solicitudes excedido",
+	"invalid authentication credentials":                                               "credenciales de autenticación no válidas",
+	"invalid or missing authentication token":                                          "token de autenticación no válido o ausente",
+	"you must be authenticated to access this resource":                                "debe autenticarse para acceder a este recurso",
+	"your user account must be activated to access this resource":                      "su cuenta de usuario debe estar activada para acceder a este recurso",
+	"your user account doesn't have the necessary permissions to access this resource": "su cuenta de usuario no tiene los permisos necesarios para acceder a este recurso",
+	"body must not be empty":                                                           "el cuerpo no debe estar vacío",
+	"body contains badly-formed JSON":                                                  "el cuerpo contiene JSON mal formado",
+	"body contains badly-formed JSON (at character {0})":                               "el cuerpo contiene JSON mal formado (en el carácter {0})",
+	"body must not be larger than {0}":                                                 "el cuerpo no debe superar {0}",
+	"body must contain a single JSON value":                                            "el cuerpo debe contener un único valor JSON",
+	"body must be multipart/form-data":                                                 "el cuerpo debe ser multipart/form-data",
+	"X-Expected-Version header must be an integer":                                     "la cabecera X-Expected-Version debe ser un número entero",
+	"poster must be a JPEG or PNG image":                                               "el póster debe ser una imagen JPEG o PNG",
+
+	// validation errors
+	"must be provided":                                                "es obligatorio",
+	"must be a string":                                                "debe ser una cadena",
+	"must be an integer":                                              "debe ser un número entero",
+	"must be a number":                                                "debe ser un número",
+	"must be a boolean":                                               "debe ser un booleano",
+	"must be an array":                                                "debe ser una lista",
+	"must be an object":                                               "debe ser un objeto",
+	"must be an integer value":                                        "debe ser un valor entero",
+	"must be a valid email address":                                   "debe ser una dirección de correo válida",
+	"must be at least {0} bytes long":                                 "debe tener al menos {0} bytes",
+	"must not be more than {0} bytes long":                            "no debe tener más de {0} bytes",
+	"must be {0} bytes long":                                          "debe tener {0} bytes",
+	"must not be larger than {0} bytes":                               "no debe superar los {0} bytes",
+	"must not be earlier than {0}":                                    "no debe ser anterior a {0}",
+	"must not be in the future":                                       "no debe estar en el futuro",
+	"must be a positive integer":                                      "debe ser un entero positivo",
+	"must be greater than zero":                                       "debe ser mayor que cero",
+	"must be a maximum of {0}":                                        "debe ser como máximo {0}",
+	"must contain at least 1 genre":                                   "debe contener al menos 1 género",
+	"must not contain more than {0} genres":                           "no debe contener más de {0} géneros",
+	"must contain at least 1 movie":                                   "debe contener al menos 1 película",
+	"must not contain more than {0} movies":                           "no debe contener más de {0} películas",
+	"must contain at least 1 permission":                              "debe contener al menos 1 permiso",
+	"unknown permission {0}":                                          "permiso desconocido {0}",
+	"must not contain duplicate values":                               "no debe contener valores duplicados",
+	"must not contain empty values":                                   "no debe contener valores vacíos",
+	"invalid sort value":                                              "valor de ordenación no válido",
+	"must be either minutes or numeric":                               "debe ser minutes o numeric",
+	"must be later than created_after":                                "debe ser posterior a created_after",
+	"a movie with this title and year already exists":                 "ya existe una película con este título y año",
+	"a user with this email address already exists":                   "ya existe un usuario con esta dirección de correo",
+	"invalid or expired activation token":                             "token de activación no válido o caducado",
+	"invalid or expired password reset token":                         "token de restablecimiento de contraseña no válido o caducado",
+	"no account awaiting activation was found for this email address": "no se encontró ninguna cuenta pendiente de activación para esta dirección de correo",
+}
@@ -0,0 +1,143 @@
+// Package i18n translates the api's english error messages for clients which ask
+// for another language with Accept-Language.
+//
+// Messages are keyed by their english text, so the code producing them doesn't
+// change. A key may contain {0}, {1}, ... placeholders standing in for the parts
+// filled in at runtime, eg "must not be more than {0} bytes long"
+package i18n
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// English is the language the messages are written in, and the fallback
+var English = language.English
+
+// Catalog holds the translations of every supported language
+type Catalog struct {
+	tags    []language.Tag
+	matcher language.Matcher
+	locales map[language.Tag]*locale
+}
+
+type locale struct {
+	exact    map[string]string
+	patterns []pattern
+}
+
+// pattern matches a message built from a key with placeholders
+type pattern struct {
+	key         string
+	rx          *regexp.Regexp
+	translation string
+	literal     int // length of the key without its placeholders
+}
+
+var placeholderRX = regexp.MustCompile(`\{\d+\}`)
+
+// New builds a Catalog from translations, a map of language to english message to
+// translated message. English needs no entry and is always supported
+func New(translations map[language.Tag]map[string]string) *Catalog {
+	c := &Catalog{
+		tags:    []language.Tag{English},
+		locales: make(map[language.Tag]*locale),
+	}
+
+	for tag, messages := range translations {
+		l := &locale{exact: make(map[string]string)}
+
+		for key, translation := range messages {
+			if !placeholderRX.MatchString(key) {
+				l.exact[key] = translation
+				continue
+			}
+
+			// every {n} becomes a named group so the translation can put the
+			// values back in, wherever they end up in the sentence
+			expr := "^"
+			last := 0
+			for _, loc := range placeholderRX.FindAllStringIndex(key, -1) {
+				name := "p" + key[loc[0]+1:loc[1]-1]
+				expr += regexp.QuoteMeta(key[last:loc[0]]) + "(?P<" + name + ">.+?)"
+				last = loc[1]
+			}
+			expr += regexp.QuoteMeta(key[last:]) + "$"
+
+			l.patterns = append(l.patterns, pattern{
+				key:         key,
+				rx:          regexp.MustCompile(expr),
+				translation: translation,
+				literal:     len(placeholderRX.ReplaceAllString(key, "")),
+			})
+		}
+
+		// a message can match several keys, eg "must be at least 8 bytes long" also
+		// fits "must be {0} bytes long", the key with the most fixed text wins. Ties go
+		// to the smaller key, the map hands them over in a different order every run
+		sort.Slice(l.patterns, func(i, j int) bool {
+			if l.patterns[i].literal != l.patterns[j].literal {
+				return l.patterns[i].literal > l.patterns[j].literal
+			}
+			return l.patterns[i].key < l.patterns[j].key
+		})
+
+		c.tags = append(c.tags, tag)
+		c.locales[tag] = l
+	}
+
+	c.matcher = language.NewMatcher(c.tags)
+	return c
+}
+
+// Negotiate picks the best supported language for an Accept-Language header value,
+// English when the header is empty, invalid or lists nothing supported
+func (c *Catalog) Negotiate(acceptLanguage string) language.Tag {
+	if acceptLanguage == "" {
+		return English
+	}
+
+	accepted, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(accepted) == 0 {
+		return English
+	}
+
+	_, index, confidence := c.matcher.Match(accepted...)
+	if confidence == language.No {
+		return English
+	}
+
+	return c.tags[index]
+}
+
+// Translate returns message in lang, or message itself when there is no translation
+func (c *Catalog) Translate(lang language.Tag, message string) string {
+	l, ok := c.locales[lang]
+	if !ok {
+		return message
+	}
+
+	if translation, ok := l.exact[message]; ok {
+		return translation
+	}
+
+	for _, p := range l.patterns {
+		match := p.rx.FindStringSubmatch(message)
+		if match == nil {
+			continue
+		}
+
+		translation := p.translation
+		for i, name := range p.rx.SubexpNames() {
+			if name != "" {
+				translation = strings.ReplaceAll(translation, "{"+name[1:]+"}", match[i])
+			}
+		}
+		return translation
+	}
+
+	return message
+}
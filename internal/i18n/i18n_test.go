@@ -0,0 +1,87 @@
+package i18n
+
+import (
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		want           language.Tag
+	}{
+		{"", English},
+		{"es", language.Spanish},
+		{"es-MX", language.Spanish},
+		{"en-US", English},
+		{"fr", English},
+		{"fr, es;q=0.5", language.Spanish},
+		{"es;q=0.5, en;q=0.9", English},
+		{"*", English},
+		{"not a language;;q=", English},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptLanguage, func(t *testing.T) {
+			if got := Default.Negotiate(tt.acceptLanguage); got != tt.want {
+				t.Errorf("Negotiate(%q) = %s, want %s", tt.acceptLanguage, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		name    string
+		lang    language.Tag
+		message string
+		want    string
+	}{
+		{"english is left alone", English, "rate limit exceeded", "rate limit exceeded"},
+		{"exact", language.Spanish, "rate limit exceeded", "límite de solicitudes excedido"},
+		{"placeholder", language.Spanish, "the PATCH method is not supported for this resource", "el método PATCH no está soportado para este recurso"},
+		{"placeholder at the end", language.Spanish, "body must not be larger than 1MB", "el cuerpo no debe superar 1MB"},
+		// "must be {0} bytes long" fits as well, the key with more fixed text wins
+		{"most specific key", language.Spanish, "must be at least 12 bytes long", "debe tener al menos 12 bytes"},
+		{"least specific key", language.Spanish, "must be 26 bytes long", "debe tener 26 bytes"},
+		{"unknown message", language.Spanish, "something nobody translated", "something nobody translated"},
+		{"unsupported language", language.French, "rate limit exceeded", "rate limit exceeded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Default.Translate(tt.lang, tt.message); got != tt.want {
+				t.Errorf("Translate(%s, %q) = %q, want %q", tt.lang, tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslateReordersPlaceholders(t *testing.T) {
+	c := New(map[language.Tag]map[string]string{
+		language.German: {"page {0} of {1}": "{1} Seiten, Seite {0}"},
+	})
+
+	got := c.Translate(language.German, "page 2 of 10")
+	if want := "10 Seiten, Seite 2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTranslateTieBreak(t *testing.T) {
+	// both keys have the same fixed text and match the message, so only the
+	// tie-break decides. Map order changes between runs, try a few
+	for range 20 {
+		c := New(map[language.Tag]map[string]string{
+			language.German: {
+				"x {0} y":    "first",
+				"x {0}{1} y": "second",
+			},
+		})
+
+		if got := c.Translate(language.German, "x ab y"); got != "first" {
+			t.Fatalf("got %q, want %q", got, "first")
+		}
+	}
+}
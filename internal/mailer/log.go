@@ -0,0 +1,20 @@
+package mailer
+
+import "log/slog"
+
+// LogMailer logs every email instead of sending it, it is used when SMTP is
+// switched off eg in local development
+type LogMailer struct {
+	logger *slog.Logger
+}
+
+// NewLogMailer returns a LogMailer writing to logger
+func NewLogMailer(logger *slog.Logger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+// Send logs the recipient and template and always succeeds
+func (m *LogMailer) Send(recipient, templateFile string, data any) error {
+	m.logger.Info("email not sent, smtp disabled", "recipient", recipient, "template", templateFile)
+	return nil
+}
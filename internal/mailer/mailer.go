@@ -2,52 +2,314 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"errors"
+	"fmt"
 	ht "html/template"
+	"io/fs"
+	netmail "net/mail"
+	"net/textproto"
+	"path"
+	"slices"
+	"strings"
 	tt "text/template"
 	"time"
 
+	"github.com/souvikmndl/greenlight-api/internal/validator"
 	"github.com/wneessen/go-mail"
 )
 
 //go:embed "templates"
 var templateFS embed.FS
 
-// Mailer stores the mail.Client instance to connect to SMTP server and sender info
-type Mailer struct {
-	client *mail.Client
-	sender string
+// ErrPermanentFailure is wrapped into a SendError when the SMTP server rejected the
+// email outright, eg bad credentials or an unknown mailbox, so it was not retried
+var ErrPermanentFailure = errors.New("permanent smtp failure")
+
+// SendError is returned by Send once it has given up on delivering an email
+type SendError struct {
+	Recipient string
+	Template  string
+	Attempts  int
+	Err       error
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("sending %s to %s failed after %d attempt(s): %v", e.Template, e.Recipient, e.Attempts, e.Err)
+}
+
+// Unwrap returns the underlying error so errors.Is and errors.As can inspect it
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// isPermanent checks for a 5xx SMTP reply in err. Those are definitive rejections,
+// unlike 4xx replies or network errors which may succeed on a later attempt
+func isPermanent(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 500
+	}
+
+	var sendErr *mail.SendError
+	if errors.As(err, &sendErr) {
+		return sendErr.ErrorCode() >= 500
+	}
+
+	return false
+}
+
+// Mailer delivers a templated email to a single recipient. SMTPMailer does it for
+// real, LogMailer and MockMailer stand in for it in dev and tests
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+// smtpClient is the part of *mail.Client that SMTPMailer uses, so tests can fake it
+type smtpClient interface {
+	DialWithContext(ctx context.Context) error
+	Send(messages ...*mail.Msg) error
+	Close() error
+}
+
+// SMTPMailer stores the sender info and the settings for connecting to the SMTP server
+// along with the templates, which are parsed once when the SMTPMailer is created
+type SMTPMailer struct {
+	// newClient builds an unconnected client, a *mail.Client outside of tests. A
+	// client holds a single connection so every session gets its own
+	newClient     func() (smtpClient, error)
+	senderName    string
+	senderAddress string
+	timeout       time.Duration
+	idleTimeout   time.Duration
+	retries       int
+	backoff       time.Duration
+	textTemplates map[string]*tt.Template
+	htmlTemplates map[string]*ht.Template
+	// sleep waits out the backoff between attempts, time.Sleep outside of tests
+	sleep func(time.Duration)
+	// now tells a session how long its connection has been idle, time.Now outside of tests
+	now func() time.Time
+}
+
+// Option configures optional settings on an SMTPMailer
+type Option func(*SMTPMailer)
+
+// WithTimeout sets the timeout for each attempt at connecting and sending, default 5s
+func WithTimeout(timeout time.Duration) Option {
+	return func(m *SMTPMailer) {
+		m.timeout = timeout
+	}
+}
+
+// WithIdleTimeout sets how long a session's connection may sit unused before the
+// next send redials instead of reusing it, default 30s. Servers drop idle clients
+// on their own, usually after a few minutes
+func WithIdleTimeout(idleTimeout time.Duration) Option {
+	return func(m *SMTPMailer) {
+		m.idleTimeout = idleTimeout
+	}
+}
+
+// WithRetries sets how many attempts Send makes before giving up, default 3
+func WithRetries(retries int) Option {
+	return func(m *SMTPMailer) {
+		m.retries = max(retries, 1)
+	}
+}
+
+// WithBackoff sets how long Send waits between failed attempts, default 500ms
+func WithBackoff(backoff time.Duration) Option {
+	return func(m *SMTPMailer) {
+		m.backoff = backoff
+	}
 }
 
 // New initialises a new mail.Dialer instance with the given SMTP settings
-func New(host string, port int, username, password, sender string) (*Mailer, error) {
-	client, err := mail.NewClient(
-		host,
-		mail.WithSMTPAuth(mail.SMTPAuthLogin),
-		mail.WithPort(port),
-		mail.WithUsername(username),
-		mail.WithPassword(password),
-		mail.WithTimeout(5*time.Second),
-	)
+func New(host string, port int, username, password, sender string, opts ...Option) (*SMTPMailer, error) {
+	from, err := netmail.ParseAddress(sender)
+	if err != nil || !validator.Matches(from.Address, validator.EmailRX) {
+		return nil, fmt.Errorf("mailer: invalid sender %q", sender)
+	}
+
+	mailer := &SMTPMailer{
+		senderName:    from.Name,
+		senderAddress: from.Address,
+		timeout:       5 * time.Second,
+		idleTimeout:   30 * time.Second,
+		retries:       3,
+		backoff:       500 * time.Millisecond,
+		sleep:         time.Sleep,
+		now:           time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(mailer)
+	}
+
+	mailer.newClient = func() (smtpClient, error) {
+		return mail.NewClient(
+			host,
+			mail.WithSMTPAuth(mail.SMTPAuthLogin),
+			mail.WithPort(port),
+			mail.WithUsername(username),
+			mail.WithPassword(password),
+			mail.WithTimeout(mailer.timeout),
+		)
+	}
+
+	// build one client up front so bad settings fail here rather than on the first send
+	_, err = mailer.newClient()
 	if err != nil {
 		return nil, err
 	}
 
-	mailer := &Mailer{
-		client: client,
-		sender: sender,
+	err = mailer.parseTemplates()
+	if err != nil {
+		return nil, err
 	}
 
 	return mailer, nil
 }
 
+// parseTemplates parses every embedded template file into its own text and html
+// template set, keyed by filename. Each file defines the same "subject", "plainBody"
+// and "htmlBody" names, so they can't share a single set
+func (m *SMTPMailer) parseTemplates() error {
+	files, err := fs.Glob(templateFS, "templates/*.tmpl")
+	if err != nil {
+		return err
+	}
+
+	m.textTemplates = make(map[string]*tt.Template, len(files))
+	m.htmlTemplates = make(map[string]*ht.Template, len(files))
+
+	for _, file := range files {
+		name := path.Base(file)
+
+		textTmpl, err := tt.New("").ParseFS(templateFS, file)
+		if err != nil {
+			return err
+		}
+
+		htmlTmpl, err := ht.New("").ParseFS(templateFS, file)
+		if err != nil {
+			return err
+		}
+
+		m.textTemplates[name] = textTmpl
+		m.htmlTemplates[name] = htmlTmpl
+	}
+
+	return nil
+}
+
+// MailOptions holds the recipients of an email, at least one To address is required
+type MailOptions struct {
+	To      []string
+	Cc      []string
+	Bcc     []string
+	ReplyTo string
+	// FromName overrides the sender's display name for this email only, the
+	// address stays the configured sender's
+	FromName string
+}
+
+// validate checks every address against validator.EmailRX, reporting all the
+// invalid ones together rather than stopping at the first
+func (o MailOptions) validate() error {
+	var errs []error
+
+	if len(o.To) == 0 {
+		errs = append(errs, errors.New("mailer: at least one recipient is required"))
+	}
+
+	addresses := slices.Concat(o.To, o.Cc, o.Bcc)
+	if o.ReplyTo != "" {
+		addresses = append(addresses, o.ReplyTo)
+	}
+
+	for _, address := range addresses {
+		if !validator.Matches(address, validator.EmailRX) {
+			errs = append(errs, fmt.Errorf("mailer: invalid email address %q", address))
+		}
+	}
+
+	err := validateFromName(o.FromName)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// fromName picks the sender display name for an email: opts.FromName if set, else
+// the template's optional "fromName" block, else the name in the configured sender
+func (m *SMTPMailer) fromName(opts MailOptions, textTmpl *tt.Template, data any) (string, error) {
+	if opts.FromName != "" {
+		return opts.FromName, nil
+	}
+
+	if textTmpl.Lookup("fromName") == nil {
+		return m.senderName, nil
+	}
+
+	name := new(bytes.Buffer)
+	err := textTmpl.ExecuteTemplate(name, "fromName", data)
+	if err != nil {
+		return "", err
+	}
+
+	fromName := strings.TrimSpace(name.String())
+
+	err = validateFromName(fromName)
+	if err != nil {
+		return "", err
+	}
+
+	return fromName, nil
+}
+
+// validateFromName rejects display names that could break out of the From header
+func validateFromName(name string) error {
+	if len(name) > 100 || strings.ContainsAny(name, "\r\n<>") {
+		return fmt.Errorf("mailer: invalid sender name %q", name)
+	}
+
+	return nil
+}
+
 // Send takes in recipient email address, template filename and dynamic
 // data of type any for the templates as any parameters
-func (m *Mailer) Send(recipient, templateFile string, data any) error {
-	textTmpl, err := tt.New("").ParseFS(templateFS, "templates/"+templateFile)
+func (m *SMTPMailer) Send(recipient, templateFile string, data any) error {
+	return m.SendWithOptions(MailOptions{To: []string{recipient}}, templateFile, data)
+}
+
+// SendWithOptions works like Send but delivers to all the recipients in opts. It
+// connects just for this email, the queue workers use a session to keep one open
+func (m *SMTPMailer) SendWithOptions(opts MailOptions, templateFile string, data any) error {
+	s, err := m.newSession()
 	if err != nil {
 		return err
 	}
+	defer s.Close()
+
+	return m.send(s, opts, templateFile, data)
+}
+
+// send renders the template and delivers it over s, retrying temporary failures
+func (m *SMTPMailer) send(s *session, opts MailOptions, templateFile string, data any) error {
+	err := opts.validate()
+	if err != nil {
+		return err
+	}
+
+	textTmpl, ok := m.textTemplates[templateFile]
+	if !ok {
+		return fmt.Errorf("mailer: unknown template %q", templateFile)
+	}
+	htmlTmpl := m.htmlTemplates[templateFile]
 
 	subject := new(bytes.Buffer)
 	err = textTmpl.ExecuteTemplate(subject, "subject", data)
@@ -61,24 +323,45 @@ func (m *Mailer) Send(recipient, templateFile string, data any) error {
 		return err
 	}
 
-	htmlTmpl, err := ht.New("").ParseFS(templateFS, "templates/"+templateFile)
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
 		return err
 	}
 
-	htmlBody := new(bytes.Buffer)
-	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	msg := mail.NewMsg()
+	err = msg.To(opts.To...)
 	if err != nil {
 		return err
 	}
 
-	msg := mail.NewMsg()
-	err = msg.To(recipient)
+	if len(opts.Cc) > 0 {
+		err = msg.Cc(opts.Cc...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(opts.Bcc) > 0 {
+		err = msg.Bcc(opts.Bcc...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.ReplyTo != "" {
+		err = msg.ReplyTo(opts.ReplyTo)
+		if err != nil {
+			return err
+		}
+	}
+
+	fromName, err := m.fromName(opts, textTmpl, data)
 	if err != nil {
 		return err
 	}
 
-	err = msg.From(m.sender)
+	err = msg.FromFormat(fromName, m.senderAddress)
 	if err != nil {
 		return err
 	}
@@ -88,15 +371,91 @@ func (m *Mailer) Send(recipient, templateFile string, data any) error {
 	msg.AddAlternativeString(mail.TypeTextHTML, htmlBody.String())
 
 	// loop for retry mechanism
-	for i := 0; i < 3; i++ {
-		err = m.client.DialAndSend(msg)
+	attempts := 0
+	for attempts < m.retries {
+		attempts++
+
+		err = s.send(msg)
 		if err == nil {
 			return nil
 		}
 
-		if i != 3 {
-			time.Sleep(500 * time.Millisecond)
+		if isPermanent(err) {
+			err = fmt.Errorf("%w: %w", ErrPermanentFailure, err)
+			break
+		}
+
+		// no point waiting after the final attempt, return the error straight away
+		if attempts < m.retries {
+			m.sleep(m.backoff)
 		}
 	}
-	return err
+
+	return &SendError{
+		Recipient: strings.Join(opts.To, ", "),
+		Template:  templateFile,
+		Attempts:  attempts,
+		Err:       err,
+	}
+}
+
+// session is a connection to the SMTP server kept open across sends, so a queue
+// worker pays for the dial and AUTH once rather than for every email. It isn't
+// safe for concurrent use, each worker owns its own
+type session struct {
+	mailer   *SMTPMailer
+	client   smtpClient
+	dialed   bool
+	lastUsed time.Time
+}
+
+// newSession returns a session which connects on its first send
+func (m *SMTPMailer) newSession() (*session, error) {
+	client, err := m.newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &session{mailer: m, client: client}, nil
+}
+
+// Send delivers an email over the session's connection, retrying the same as SMTPMailer.Send
+func (s *session) Send(recipient, templateFile string, data any) error {
+	return s.mailer.send(s, MailOptions{To: []string{recipient}}, templateFile, data)
+}
+
+// send delivers msg, dialing first if there is no connection or it has been idle
+// too long. After a failure the connection is dropped so the next attempt redials
+func (s *session) send(msg *mail.Msg) error {
+	if s.dialed && s.mailer.now().Sub(s.lastUsed) > s.mailer.idleTimeout {
+		s.Close()
+	}
+
+	if !s.dialed {
+		// the client applies the connect timeout itself
+		err := s.client.DialWithContext(context.Background())
+		if err != nil {
+			return err
+		}
+		s.dialed = true
+	}
+
+	err := s.client.Send(msg)
+	if err != nil {
+		s.Close()
+		return err
+	}
+
+	s.lastUsed = s.mailer.now()
+	return nil
+}
+
+// Close quits the connection if one is open
+func (s *session) Close() error {
+	if !s.dialed {
+		return nil
+	}
+
+	s.dialed = false
+	return s.client.Close()
 }
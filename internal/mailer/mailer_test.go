@@ -0,0 +1,241 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	ht "html/template"
+	"io"
+	"net/textproto"
+	"testing"
+	tt "text/template"
+	"time"
+
+	"github.com/wneessen/go-mail"
+)
+
+// fakeClient fails the first len(errs) sends with those errors and succeeds after,
+// counting how often it was dialed and closed along the way
+type fakeClient struct {
+	errs   []error
+	calls  int
+	dials  int
+	closes int
+}
+
+func (c *fakeClient) DialWithContext(ctx context.Context) error {
+	c.dials++
+	return nil
+}
+
+func (c *fakeClient) Send(messages ...*mail.Msg) error {
+	c.calls++
+	if c.calls <= len(c.errs) {
+		return c.errs[c.calls-1]
+	}
+	return nil
+}
+
+func (c *fakeClient) Close() error {
+	c.closes++
+	return nil
+}
+
+// newTestMailer returns an SMTPMailer sending through client, every backoff it
+// waits is recorded in sleeps instead of slept
+func newTestMailer(t *testing.T, client smtpClient, sleeps *[]time.Duration) *SMTPMailer {
+	t.Helper()
+
+	m := &SMTPMailer{
+		newClient: func() (smtpClient, error) {
+			return client, nil
+		},
+		senderName:    "Greenlight",
+		senderAddress: "souvik@example.com",
+		idleTimeout:   30 * time.Second,
+		retries:       3,
+		backoff:       500 * time.Millisecond,
+		sleep: func(d time.Duration) {
+			*sleeps = append(*sleeps, d)
+		},
+		now: time.Now,
+	}
+
+	err := m.parseTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestSendRetries(t *testing.T) {
+	temporary := errors.New("connection refused")
+	permanent := &textproto.Error{Code: 550, Msg: "no such user"}
+
+	tests := []struct {
+		name          string
+		errs          []error
+		wantAttempts  int
+		wantSleeps    int
+		wantErr       bool
+		wantPermanent bool
+	}{
+		{"first attempt works", nil, 1, 0, false, false},
+		{"second attempt works", []error{temporary}, 2, 1, false, false},
+		// no backoff after the final attempt, the error is returned straight away
+		{"every attempt fails", []error{temporary, temporary, temporary}, 3, 2, true, false},
+		{"permanent failure", []error{permanent}, 1, 0, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{errs: tt.errs}
+			var sleeps []time.Duration
+
+			m := newTestMailer(t, client, &sleeps)
+
+			err := m.Send("alice@example.com", "user_welcome.tmpl", welcomeData)
+
+			if client.calls != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", client.calls, tt.wantAttempts)
+			}
+			if len(sleeps) != tt.wantSleeps {
+				t.Errorf("got %d sleeps, want %d", len(sleeps), tt.wantSleeps)
+			}
+			for _, d := range sleeps {
+				if d != m.backoff {
+					t.Errorf("slept %s, want the %s backoff", d, m.backoff)
+				}
+			}
+
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+				return
+			}
+
+			var sendErr *SendError
+			if !errors.As(err, &sendErr) {
+				t.Fatalf("got error %v, want a *SendError", err)
+			}
+			if sendErr.Attempts != tt.wantAttempts {
+				t.Errorf("SendError has %d attempts, want %d", sendErr.Attempts, tt.wantAttempts)
+			}
+			if errors.Is(err, ErrPermanentFailure) != tt.wantPermanent {
+				t.Errorf("got permanent %t, want %t", errors.Is(err, ErrPermanentFailure), tt.wantPermanent)
+			}
+		})
+	}
+}
+
+var welcomeData = map[string]any{"activationToken": "token", "userID": 1}
+
+func TestSessionReusesConnection(t *testing.T) {
+	temporary := errors.New("connection reset")
+
+	tests := []struct {
+		name      string
+		errs      []error
+		sends     int
+		idle      time.Duration
+		wantDials int
+	}{
+		{"one dial for every send", nil, 5, 0, 1},
+		// the failed attempt drops the connection and the retry dials again
+		{"redial after an error", []error{temporary}, 5, 0, 2},
+		{"redial after going idle", nil, 3, time.Minute, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{errs: tt.errs}
+			var sleeps []time.Duration
+
+			m := newTestMailer(t, client, &sleeps)
+
+			// every send moves the clock on by idle
+			clock := time.Now()
+			m.now = func() time.Time {
+				clock = clock.Add(tt.idle)
+				return clock
+			}
+
+			s, err := m.newSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for range tt.sends {
+				err := s.Send("alice@example.com", "user_welcome.tmpl", welcomeData)
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+			}
+
+			if client.dials != tt.wantDials {
+				t.Errorf("got %d dials for %d sends, want %d", client.dials, tt.sends, tt.wantDials)
+			}
+
+			err = s.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if client.closes != client.dials {
+				t.Errorf("got %d closes, want one for each of the %d dials", client.closes, client.dials)
+			}
+		})
+	}
+}
+
+var benchData = map[string]any{"activationToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU", "userID": 1}
+
+// render executes the three blocks of an email the way SendWithOptions does
+func render(b *testing.B, textTmpl *tt.Template, htmlTmpl *ht.Template) {
+	for _, name := range []string{"subject", "plainBody"} {
+		err := textTmpl.ExecuteTemplate(io.Discard, name, benchData)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	err := htmlTmpl.ExecuteTemplate(io.Discard, "htmlBody", benchData)
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkTemplatesParsedPerSend is what Send used to do, parsing the template
+// files again for every email
+func BenchmarkTemplatesParsedPerSend(b *testing.B) {
+	for b.Loop() {
+		textTmpl, err := tt.New("").ParseFS(templateFS, "templates/user_welcome.tmpl")
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		htmlTmpl, err := ht.New("").ParseFS(templateFS, "templates/user_welcome.tmpl")
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		render(b, textTmpl, htmlTmpl)
+	}
+}
+
+// BenchmarkTemplatesCached executes the templates parsed once by New
+func BenchmarkTemplatesCached(b *testing.B) {
+	m := &SMTPMailer{}
+
+	err := m.parseTemplates()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	textTmpl := m.textTemplates["user_welcome.tmpl"]
+	htmlTmpl := m.htmlTemplates["user_welcome.tmpl"]
+
+	for b.Loop() {
+		render(b, textTmpl, htmlTmpl)
+	}
+}
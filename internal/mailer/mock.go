@@ -0,0 +1,32 @@
+package mailer
+
+import "sync"
+
+// MockMailer records every email it is asked to send so tests can inspect them,
+// nothing is ever delivered
+type MockMailer struct {
+	// Err, if set, is returned by every call to Send
+	Err error
+
+	mu   sync.Mutex
+	sent []Job
+}
+
+// Send records the email and returns m.Err
+func (m *MockMailer) Send(recipient, templateFile string, data any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, Job{Recipient: recipient, TemplateFile: templateFile, Data: data})
+	return m.Err
+}
+
+// Sent returns a copy of every email recorded so far, oldest first
+func (m *MockMailer) Sent() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sent := make([]Job, len(m.sent))
+	copy(sent, m.sent)
+	return sent
+}
@@ -0,0 +1,146 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+var (
+	// ErrQueueFull is returned by Enqueue when every slot in the queue is taken
+	ErrQueueFull = errors.New("mail queue is full")
+	// ErrQueueClosed is returned by Enqueue once Shutdown has been called
+	ErrQueueClosed = errors.New("mail queue is closed")
+)
+
+// Job is a single email waiting to be sent by the queue workers
+type Job struct {
+	Recipient    string
+	TemplateFile string
+	Data         any
+}
+
+// sessionMailer is implemented by mailers that can keep a connection open across
+// sends, the queue gives each worker its own session when the mailer supports it
+type sessionMailer interface {
+	newSession() (*session, error)
+}
+
+// Queue sends emails from a fixed pool of worker goroutines so callers
+// never have to wait on the SMTP server
+type Queue struct {
+	mailer Mailer
+	logger *slog.Logger
+	jobs   chan Job
+	wg     sync.WaitGroup
+
+	// mu guards closed, so Enqueue never sends on the closed jobs channel
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewQueue starts workers goroutines sending the jobs from a queue
+// which can hold up to size jobs waiting to be picked up
+func NewQueue(mailer Mailer, logger *slog.Logger, workers, size int) *Queue {
+	q := &Queue{
+		mailer: mailer,
+		logger: logger,
+		jobs:   make(chan Job, size),
+	}
+
+	for range max(workers, 1) {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	return q
+}
+
+// Enqueue adds a job to the queue without blocking, it returns ErrQueueFull
+// if the workers have fallen too far behind to accept more
+func (q *Queue) Enqueue(job Job) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Shutdown stops accepting new jobs and waits for the workers to send everything
+// already queued. It returns the ctx error if that takes longer than ctx allows
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// work sends jobs until the jobs channel is closed and drained
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	var mailer Mailer = q.mailer
+
+	if sm, ok := q.mailer.(sessionMailer); ok {
+		s, err := sm.newSession()
+		if err != nil {
+			q.logger.Error("opening mail session failed, connecting per email", "error", err.Error())
+		} else {
+			defer s.Close()
+			mailer = s
+		}
+	}
+
+	for job := range q.jobs {
+		q.send(mailer, job)
+	}
+}
+
+// send delivers a single job, a panic is recovered so it can't take the worker down
+func (q *Queue) send(mailer Mailer, job Job) {
+	defer func() {
+		if err := recover(); err != nil {
+			q.logger.Error(fmt.Sprintf("%v", err), "recipient", job.Recipient, "template", job.TemplateFile)
+		}
+	}()
+
+	err := mailer.Send(job.Recipient, job.TemplateFile, job.Data)
+	if err != nil {
+		var sendErr *SendError
+		if errors.As(err, &sendErr) {
+			q.logger.Error(sendErr.Err.Error(), "recipient", sendErr.Recipient, "template", sendErr.Template,
+				"attempts", sendErr.Attempts, "permanent", errors.Is(err, ErrPermanentFailure))
+			return
+		}
+
+		q.logger.Error(err.Error(), "recipient", job.Recipient, "template", job.TemplateFile)
+		return
+	}
+
+	q.logger.Info("email sent", "recipient", job.Recipient, "template", job.TemplateFile)
+}
@@ -0,0 +1,147 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// blockingMailer holds every Send until release is closed, signalling on started
+// as each one begins
+type blockingMailer struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingMailer() *blockingMailer {
+	// started is buffered so Sends nobody waits on don't block on it
+	return &blockingMailer{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (m *blockingMailer) Send(recipient, templateFile string, data any) error {
+	m.started <- struct{}{}
+	<-m.release
+	return nil
+}
+
+func newTestQueue(mailer Mailer, workers, size int) *Queue {
+	return NewQueue(mailer, slog.New(slog.NewTextHandler(io.Discard, nil)), workers, size)
+}
+
+func TestQueueShutdownDrains(t *testing.T) {
+	mock := &MockMailer{}
+	q := newTestQueue(mock, 2, 10)
+
+	for _, recipient := range []string{"alice@example.com", "bob@example.com", "carol@example.com"} {
+		err := q.Enqueue(Job{Recipient: recipient, TemplateFile: "user_welcome.tmpl"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := q.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if n := len(mock.Sent()); n != 3 {
+		t.Errorf("got %d emails sent, want 3", n)
+	}
+
+	err = q.Enqueue(Job{Recipient: "dave@example.com", TemplateFile: "user_welcome.tmpl"})
+	if !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("got error %v after shutdown, want ErrQueueClosed", err)
+	}
+
+	// a second shutdown must not close the jobs channel again
+	err = q.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("got error %v from a second shutdown", err)
+	}
+}
+
+func TestQueueWorkerKeepsConnection(t *testing.T) {
+	client := &fakeClient{}
+	var sleeps []time.Duration
+
+	q := newTestQueue(newTestMailer(t, client, &sleeps), 1, 10)
+
+	for range 5 {
+		err := q.Enqueue(Job{Recipient: "alice@example.com", TemplateFile: "user_welcome.tmpl", Data: welcomeData})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := q.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if client.calls != 5 {
+		t.Errorf("got %d emails sent, want 5", client.calls)
+	}
+	if client.dials != 1 {
+		t.Errorf("got %d dials, want 1", client.dials)
+	}
+	// the worker quits the connection on its way out
+	if client.closes != 1 {
+		t.Errorf("got %d closes, want 1", client.closes)
+	}
+}
+
+func TestQueueShutdownTimeout(t *testing.T) {
+	mailer := newBlockingMailer()
+	q := newTestQueue(mailer, 1, 1)
+
+	err := q.Enqueue(Job{Recipient: "alice@example.com", TemplateFile: "user_welcome.tmpl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-mailer.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = q.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want context.DeadlineExceeded", err)
+	}
+
+	// the worker is let go so it finishes, the queue still drains afterwards
+	close(mailer.release)
+
+	err = q.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("got error %v once the send finished", err)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	mailer := newBlockingMailer()
+	q := newTestQueue(mailer, 1, 1)
+	defer q.Shutdown(context.Background())
+	defer close(mailer.release)
+
+	job := Job{Recipient: "alice@example.com", TemplateFile: "user_welcome.tmpl"}
+
+	// the first job keeps the only worker busy, the second takes the only slot
+	err := q.Enqueue(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-mailer.started
+
+	err = q.Enqueue(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = q.Enqueue(job)
+	if !errors.Is(err, ErrQueueFull) {
+		t.Errorf("got error %v, want ErrQueueFull", err)
+	}
+}
@@ -0,0 +1,240 @@
+// Package testhelpers connects tests to a real Postgres database, so model and
+// handler tests run against the same SQL as production rather than mocks.
+//
+// The database is the one named by GREENLIGHT_TEST_DB_DSN, it gets the migrations
+// in ./migrations applied on first use. Use a throwaway database for it, never one
+// holding data you care about. Tests needing it are skipped when it isn't set
+package testhelpers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// DSNEnv is the environment variable holding the test database DSN
+const DSNEnv = "GREENLIGHT_TEST_DB_DSN"
+
+// migrationLock is the advisory lock key held while migrating, go test runs the
+// packages in parallel and each of them may try to migrate the same database
+const migrationLock = 7_346_001
+
+var (
+	once    sync.Once
+	db      *sql.DB
+	openErr error
+)
+
+// DB returns the connection pool of the test database, migrated up to the latest
+// version. t is skipped when GREENLIGHT_TEST_DB_DSN isn't set. The pool is shared
+// by every test in the package, prefer Tx unless a test has to commit
+func DB(t testing.TB) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv(DSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set, skipping database test", DSNEnv)
+	}
+
+	once.Do(func() {
+		db, openErr = open(dsn)
+	})
+	if openErr != nil {
+		t.Fatalf("test database: %v", openErr)
+	}
+
+	return db
+}
+
+// Tx begins a transaction on the test database which is rolled back once t and its
+// subtests have finished, so nothing a test writes is seen by any other test
+func Tx(t testing.TB) *sql.Tx {
+	t.Helper()
+
+	tx, err := DB(t).Begin()
+	if err != nil {
+		t.Fatalf("test database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		tx.Rollback()
+	})
+
+	return tx
+}
+
+func open(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = migrate(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	return db, nil
+}
+
+// migrate applies the up migrations newer than the version in schema_migrations.
+// It keeps that table the way golang-migrate does, so a database migrated with the
+// migrate cli (see make db/migrations/up) is picked up where it was left
+func migrate(db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// advisory locks belong to a session, so everything runs on one connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLock)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLock)
+
+	// users.email is a citext column, the extension is normally set up by hand
+	_, err = conn.ExecContext(ctx, `CREATE EXTENSION IF NOT EXISTS citext`)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)`)
+	if err != nil {
+		return err
+	}
+
+	var (
+		current int64
+		dirty   bool
+	)
+
+	err = conn.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&current, &dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("database is dirty at version %d, fix it with the migrate cli", current)
+	}
+
+	files, err := upMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.version <= current {
+			continue
+		}
+
+		query, err := os.ReadFile(file.path)
+		if err != nil {
+			return err
+		}
+
+		err = apply(ctx, conn, file.version, string(query))
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(file.path), err)
+		}
+	}
+
+	return nil
+}
+
+// apply runs one migration and records its version in the same transaction
+func apply(ctx context.Context, conn *sql.Conn, version int64, query string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// an empty migration still moves the version on
+	if strings.TrimSpace(query) != "" {
+		_, err = tx.ExecContext(ctx, query)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type migrationFile struct {
+	version int64
+	path    string
+}
+
+// upMigrations lists the *.up.sql files in ./migrations, oldest first
+func upMigrations() ([]migrationFile, error) {
+	// the migrations dir is found relative to this file, so it doesn't matter
+	// which package directory go test runs in
+	_, thisFile, _, ok := runtime.Caller(0)
+	if !ok {
+		return nil, errors.New("can't locate the migrations directory")
+	}
+	dir := filepath.Join(filepath.Dir(thisFile), "..", "..", "migrations")
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.up.sql"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no migrations found in %s", dir)
+	}
+
+	files := make([]migrationFile, 0, len(paths))
+
+	for _, path := range paths {
+		prefix, _, _ := strings.Cut(filepath.Base(path), "_")
+
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has no version number", filepath.Base(path))
+		}
+
+		files = append(files, migrationFile{version: version, path: path})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].version < files[j].version
+	})
+
+	return files, nil
+}
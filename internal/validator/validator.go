@@ -1,8 +1,14 @@
 package validator
 
 import (
+	"cmp"
+	"net/mail"
 	"regexp"
 	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
 )
 
 var (
@@ -10,9 +16,33 @@ var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
-// Validator struct will validate our json payloads
+// error codes for ValidationError, clients switch on these rather than on the
+// english messages, eg to show a translated one
+const (
+	CodeInvalid       = "invalid"
+	CodeRequired      = "required"
+	CodeTooShort      = "too_short"
+	CodeTooLong       = "too_long"
+	CodeTooSmall      = "too_small"
+	CodeTooLarge      = "too_large"
+	CodeDuplicate     = "duplicate"
+	CodeNotPermitted  = "not_permitted"
+	CodeNotFound      = "not_found"
+	CodeAlreadyExists = "already_exists"
+)
+
+// ValidationError is a failed check with a machine-readable code next to its message
+type ValidationError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Validator struct will validate our json payloads. Errors keeps the message of the
+// first failed check per key, Details has the same errors with their codes
 type Validator struct {
-	Errors map[string]string
+	Errors  map[string]string
+	details []ValidationError
 }
 
 // New creates an empty validator struct
@@ -25,10 +55,16 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
-// AddError adds an error msg if it doesnt already exist
+// AddError adds an error msg if it doesnt already exist, with CodeInvalid as its code
 func (v *Validator) AddError(key, message string) {
+	v.AddErrorCode(key, CodeInvalid, message)
+}
+
+// AddErrorCode adds an error msg and its code if there is none for key yet
+func (v *Validator) AddErrorCode(key, code, message string) {
 	if _, exists := v.Errors[key]; !exists {
 		v.Errors[key] = message
+		v.details = append(v.details, ValidationError{Field: key, Code: code, Message: message})
 	}
 }
 
@@ -39,6 +75,85 @@ func (v *Validator) Check(ok bool, key, message string) {
 	}
 }
 
+// CheckCode adds an error msg and its code if a validation check is not ok
+func (v *Validator) CheckCode(ok bool, key, code, message string) {
+	if !ok {
+		v.AddErrorCode(key, code, message)
+	}
+}
+
+// Details returns the errors with their codes, in the order they were added
+func (v *Validator) Details() []ValidationError {
+	return v.details
+}
+
+// Checker is satisfied by both Validator and MultiValidator, so validation funcs
+// taking one can fill either kind
+type Checker interface {
+	AddError(key, message string)
+	AddErrorCode(key, code, message string)
+	Check(ok bool, key, message string)
+	CheckCode(ok bool, key, code, message string)
+	Valid() bool
+}
+
+// MultiValidator collects every failed check for a key instead of only the first,
+// eg a password that is both too short and missing a digit. Validator stays the
+// default, handlers opt into this one when they want all the messages
+type MultiValidator struct {
+	Errors  map[string][]string
+	details []ValidationError
+}
+
+// NewMulti creates an empty MultiValidator
+func NewMulti() *MultiValidator {
+	return &MultiValidator{Errors: make(map[string][]string)}
+}
+
+// Valid checks if we have any error entries in the MultiValidator
+func (v *MultiValidator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// AddError appends an error msg for key, the same msg is only kept once
+func (v *MultiValidator) AddError(key, message string) {
+	v.AddErrorCode(key, CodeInvalid, message)
+}
+
+// AddErrorCode appends an error msg and its code for key, the same msg is only kept once
+func (v *MultiValidator) AddErrorCode(key, code, message string) {
+	if !slices.Contains(v.Errors[key], message) {
+		v.Errors[key] = append(v.Errors[key], message)
+		v.details = append(v.details, ValidationError{Field: key, Code: code, Message: message})
+	}
+}
+
+// AddErrors appends several error msgs for key at once
+func (v *MultiValidator) AddErrors(key string, messages ...string) {
+	for _, message := range messages {
+		v.AddError(key, message)
+	}
+}
+
+// Check adds an error msg for key if a validation check is not ok
+func (v *MultiValidator) Check(ok bool, key, message string) {
+	if !ok {
+		v.AddError(key, message)
+	}
+}
+
+// CheckCode adds an error msg and its code for key if a validation check is not ok
+func (v *MultiValidator) CheckCode(ok bool, key, code, message string) {
+	if !ok {
+		v.AddErrorCode(key, code, message)
+	}
+}
+
+// Details returns every error with its code, in the order they were added
+func (v *MultiValidator) Details() []ValidationError {
+	return v.details
+}
+
 // PermittedValue is a generic func which returns true if a specific value
 // is in a list of permitted values
 func PermittedValue[T comparable](value T, permittedValues ...T) bool {
@@ -50,6 +165,52 @@ func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
+// NotMatches returns true if a string value does not match a specific regexp pattern,
+// eg to reject values on a blocklist
+func NotMatches(value string, rx *regexp.Regexp) bool {
+	return !rx.MatchString(value)
+}
+
+// MinChars returns true if a string value has at least n characters, characters
+// are counted as runes so multi-byte letters count once
+func MinChars(value string, n int) bool {
+	return utf8.RuneCountInString(value) >= n
+}
+
+// MaxChars returns true if a string value has at most n characters (runes)
+func MaxChars(value string, n int) bool {
+	return utf8.RuneCountInString(value) <= n
+}
+
+// Between is a generic func which returns true if value is within [min, max]
+func Between[T cmp.Ordered](value, min, max T) bool {
+	return value >= min && value <= max
+}
+
+// EmailRFC returns true if value is a bare RFC 5322 address with a domain that
+// converts to a valid IDNA hostname. Unlike EmailRX it accepts unicode domains,
+// eg user@münchen.de, and quoted local parts like "john doe"@example.com
+func EmailRFC(value string) bool {
+	// display names, angle brackets and comments are all valid in an address
+	// header, but not for an address on its own
+	if len(value) > 254 || strings.TrimSpace(value) != value || strings.ContainsAny(value, "<>()") {
+		return false
+	}
+
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Name != "" {
+		return false
+	}
+
+	at := strings.LastIndex(addr.Address, "@")
+	if at < 1 {
+		return false
+	}
+
+	_, err = idna.Lookup.ToASCII(addr.Address[at+1:])
+	return err == nil
+}
+
 // Unique is a generic func to check if all values in a slice are unique
 func Unique[T comparable](values []T) bool {
 	uniqueValues := make(map[T]bool)
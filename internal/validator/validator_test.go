@@ -0,0 +1,102 @@
+package validator
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMultiValidator(t *testing.T) {
+	v := NewMulti()
+
+	if !v.Valid() {
+		t.Fatal("a new MultiValidator is not valid")
+	}
+
+	v.CheckCode(false, "password", CodeTooShort, "must be at least 12 bytes long")
+	v.Check(false, "password", "must contain a digit")
+	v.Check(true, "password", "must not be empty")
+	// the same message twice is only recorded once
+	v.Check(false, "password", "must contain a digit")
+	v.AddErrors("email", "must be provided", "must be a valid email address")
+
+	if v.Valid() {
+		t.Fatal("got valid after failed checks")
+	}
+
+	wantErrors := map[string][]string{
+		"password": {"must be at least 12 bytes long", "must contain a digit"},
+		"email":    {"must be provided", "must be a valid email address"},
+	}
+
+	if len(v.Errors) != len(wantErrors) {
+		t.Errorf("got errors %v, want %v", v.Errors, wantErrors)
+	}
+	for key, want := range wantErrors {
+		if !slices.Equal(v.Errors[key], want) {
+			t.Errorf("got %s errors %q, want %q", key, v.Errors[key], want)
+		}
+	}
+
+	wantDetails := []ValidationError{
+		{Field: "password", Code: CodeTooShort, Message: "must be at least 12 bytes long"},
+		{Field: "password", Code: CodeInvalid, Message: "must contain a digit"},
+		{Field: "email", Code: CodeInvalid, Message: "must be provided"},
+		{Field: "email", Code: CodeInvalid, Message: "must be a valid email address"},
+	}
+
+	if got := v.Details(); !slices.Equal(got, wantDetails) {
+		t.Errorf("got details %+v, want %+v", got, wantDetails)
+	}
+}
+
+func TestValidatorKeepsFirstError(t *testing.T) {
+	// unlike MultiValidator, Validator stops at the first message per key
+	v := New()
+	v.Check(false, "password", "must be at least 12 bytes long")
+	v.Check(false, "password", "must contain a digit")
+
+	if got := v.Errors["password"]; got != "must be at least 12 bytes long" {
+		t.Errorf("got password error %q, want the first one", got)
+	}
+	if len(v.Details()) != 1 {
+		t.Errorf("got %d details, want 1", len(v.Details()))
+	}
+}
+
+func TestEmailRFC(t *testing.T) {
+	tests := []struct {
+		email   string
+		wantRFC bool
+		wantRX  bool
+	}{
+		{"alice@example.com", true, true},
+		{"alice+tag@sub.example.co.uk", true, true},
+		{"user@münchen.de", true, false},
+		{"user@xn--mnchen-3ya.de", true, true},
+		{"用户@例子.广告", true, false},
+		{`"john doe"@example.com`, true, false},
+		{`"john@doe"@example.com`, true, false},
+		{"", false, false},
+		{"alice", false, false},
+		{"alice@", false, false},
+		{"@example.com", false, false},
+		{"alice@@example.com", false, false},
+		{" alice@example.com", false, false},
+		{"Alice <alice@example.com>", false, false},
+		{"alice@example.com (Alice)", false, false},
+		{"alice@exa mple.com", false, false},
+		{"alice@-example.com", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			if got := EmailRFC(tt.email); got != tt.wantRFC {
+				t.Errorf("EmailRFC(%q) = %t, want %t", tt.email, got, tt.wantRFC)
+			}
+			// EmailRX stays the default and is unchanged
+			if got := Matches(tt.email, EmailRX); got != tt.wantRX {
+				t.Errorf("EmailRX matches %q = %t, want %t", tt.email, got, tt.wantRX)
+			}
+		})
+	}
+}
@@ -10,3 +10,30 @@ func Version() string {
 	}
 	return ""
 }
+
+// RevisionTime returns the commit time of the revision our build was made from
+func RevisionTime() string {
+	return setting("vcs.time")
+}
+
+// Modified reports whether the working tree had uncommitted changes at build time
+func Modified() bool {
+	return setting("vcs.modified") == "true"
+}
+
+// setting looks up a key in the build settings, these are only present
+// when the binary is built from inside a vcs checkout
+func setting(key string) string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	for _, s := range bi.Settings {
+		if s.Key == key {
+			return s.Value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1 @@
+DELETE FROM permissions WHERE code = 'permissions:write';
\ No newline at end of file
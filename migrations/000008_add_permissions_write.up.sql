@@ -0,0 +1,3 @@
+INSERT INTO permissions (code)
+VALUES
+    ('permissions:write');
\ No newline at end of file
@@ -0,0 +1 @@
+DELETE FROM permissions WHERE code = 'users:read';
\ No newline at end of file
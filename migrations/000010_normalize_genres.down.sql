@@ -0,0 +1 @@
+-- the original spelling of each genre isn't kept, so this can't be undone
@@ -0,0 +1,17 @@
+-- bring existing genres in line with data.NormalizeGenres: trimmed, lowercased,
+-- inner whitespace collapsed and deduplicated, keeping the first occurrence's position
+UPDATE movies
+SET genres = normalized.genres, version = version + 1
+FROM (
+    SELECT id, ARRAY(
+        SELECT g.genre
+        FROM (
+            SELECT lower(regexp_replace(trim(genre), '\s+', ' ', 'g')) AS genre, min(position) AS position
+            FROM unnest(movies.genres) WITH ORDINALITY AS u(genre, position)
+            GROUP BY 1
+        ) AS g
+        ORDER BY g.position
+    ) AS genres
+    FROM movies
+) AS normalized
+WHERE movies.id = normalized.id AND movies.genres <> normalized.genres;
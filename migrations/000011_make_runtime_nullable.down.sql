@@ -0,0 +1,4 @@
+-- unknown runtimes fall back to 0, which the runtime check constraint allows
+UPDATE movies SET runtime = 0 WHERE runtime IS NULL;
+
+ALTER TABLE movies ALTER COLUMN runtime SET NOT NULL;
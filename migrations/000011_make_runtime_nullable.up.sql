@@ -0,0 +1 @@
+ALTER TABLE movies ALTER COLUMN runtime DROP NOT NULL;
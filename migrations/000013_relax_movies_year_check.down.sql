@@ -0,0 +1,4 @@
+-- fails while a movie from before 1888 is stored, those have to go first
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_year_check;
+
+ALTER TABLE movies ADD CONSTRAINT movies_year_check CHECK (year BETWEEN 1888 AND date_part('year', now()));
@@ -0,0 +1,5 @@
+-- the earliest year is -movie-min-year, checked by the api. The database only keeps
+-- out years which can't be right whatever the floor, so lowering it doesn't need a migration
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_year_check;
+
+ALTER TABLE movies ADD CONSTRAINT movies_year_check CHECK (year >= 1 AND year <= date_part('year', now()));